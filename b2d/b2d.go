@@ -222,6 +222,16 @@ func (b *b2dISO) version() (string, error) {
 	return ver, nil
 }
 
+// ISOVersion returns the version tag of the Boot2Docker ISO at path.
+func ISOVersion(path string) (string, error) {
+	b := &b2dISO{
+		commonIsoPath:  path,
+		volumeIDOffset: defaultVolumeIDOffset,
+		volumeIDLength: defaultVolumeIDLength,
+	}
+	return b.version()
+}
+
 type B2dUtils struct {
 	releaseGetter
 	iso
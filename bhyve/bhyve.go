@@ -7,11 +7,12 @@ package bhyve
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
 	"os/user"
-	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/docker/machine/libmachine/drivers"
 	"github.com/docker/machine/libmachine/engine"
@@ -34,31 +35,101 @@ const (
 	isoFilename           = "boot2docker.iso"
 	diskname              = "guest.img"
 	defaultBhyveVMName    = ""
+	defaultNICModel       = "virtio-net"
+	defaultDiskType       = "virtio-blk"
+	defaultStorage        = "file"
+	defaultOvercommit     = overcommitWarn
+	defaultVNCResolution  = "1024x768"
+	defaultVNCBind        = "127.0.0.1"
+	defaultShareType      = shareType9p
+	defaultIPDiscovery    = ipDiscoveryLease
+	defaultClockDrift     = 2   // seconds
+	defaultClockSync      = 300 // seconds
 )
 
+type ExtraDisk struct {
+	Filename string
+	Size     int64
+	Type     string
+}
+
+type NetworkInterface struct {
+	Bridge     string
+	MACAddress string
+	Model      string
+	NetDev     string
+}
+
 type Driver struct {
 	*drivers.BaseDriver
-	EnginePort     int
-	DiskSize       int64
-	MemSize        int64
-	CPUcount       int
-	NetDev         string
-	MACAddress     string
-	Bridge         string
-	DHCPRange      string
-	NMDMDev        string
-	Boot2DockerURL string
-	Subnet         string
-	BhyveVMName    string
+	EnginePort          int
+	DiskSize            int64
+	MemSize             int64
+	CPUcount            int
+	NetDev              string
+	MACAddress          string
+	Bridge              string
+	DHCPRange           string
+	NMDMDev             string
+	Boot2DockerURL      string
+	Subnet              string
+	BhyveVMName         string
+	Networks            []NetworkInterface
+	ExtraDisks          []ExtraDisk
+	Storage             string
+	ZVol                string
+	ZVolOrigin          string
+	CloneFrom           string
+	GrowDataPartition   bool
+	CPUSockets          int
+	CPUCores            int
+	CPUThreads          int
+	Overcommit          string
+	WireMemory          bool
+	NoHLTExit           bool
+	NoPauseExit         bool
+	CPUPin              string
+	ResourceLimits      map[string]string
+	Passthru            []string
+	VNC                 bool
+	VNCResolution       string
+	VNCBind             string
+	VNCPort             int
+	VNCPassword         string
+	Shares              []Share
+	ShareType           string
+	ConsolePorts        []string
+	IPDiscovery         string
+	RTCUTC              bool
+	ClockDriftThreshold int
+	ClockSyncInterval   int
+	Paused              bool
 }
 
 func (d *Driver) Create() error {
+	if d.CloneFrom != "" {
+		return d.createFromClone()
+	}
+
 	if err := copyIsoToMachineDir(d.StorePath, d.Boot2DockerURL, d.MachineName); err != nil {
 		return err
 	}
 
-	if err := generateRawDiskImage(d.GetSSHKeyPath(), d.ResolveStorePath(diskname), d.DiskSize); err != nil {
-		return err
+	if dataset, ok := zfsDataset(d.Storage); ok {
+		d.ZVol = dataset + "/" + d.BhyveVMName
+		if err := generateZVolDiskImage(d.GetSSHKeyPath(), d.ZVol, d.DiskSize); err != nil {
+			return err
+		}
+	} else {
+		if err := generateRawDiskImage(d.GetSSHKeyPath(), d.ResolveStorePath(diskname), d.DiskSize); err != nil {
+			return err
+		}
+	}
+
+	for _, disk := range d.ExtraDisks {
+		if err := createSparseDisk(d.ResolveStorePath(disk.Filename), disk.Size); err != nil {
+			return err
+		}
 	}
 
 	log.Infof("Starting %s...", d.MachineName)
@@ -116,6 +187,174 @@ func (d *Driver) GetCreateFlags() []mcnflag.Flag {
 			Usage:  "URL for boot2docker.iso",
 			EnvVar: "BHYVE_BOOT2DOCKERURL",
 		},
+		mcnflag.StringSliceFlag{
+			Name:   "bhyve-network",
+			Usage:  "Additional NIC as bridge[,mac=<address>][,model=virtio-net|e1000], may be repeated",
+			EnvVar: "BHYVE_NETWORK",
+			Value:  []string{},
+		},
+		mcnflag.StringSliceFlag{
+			Name:   "bhyve-extra-disk",
+			Usage:  "Additional disk as size in MB[,virtio-blk|nvme], may be repeated",
+			EnvVar: "BHYVE_EXTRA_DISK",
+			Value:  []string{},
+		},
+		mcnflag.StringFlag{
+			Name:   "bhyve-storage",
+			Usage:  "Storage backend for guest disk: file or zfs:<pool/dataset>",
+			EnvVar: "BHYVE_STORAGE",
+			Value:  defaultStorage,
+		},
+		mcnflag.StringFlag{
+			Name:   "bhyve-clone-from",
+			Usage:  "Create the machine as a clone of this stopped machine",
+			EnvVar: "BHYVE_CLONE_FROM",
+		},
+		mcnflag.StringFlag{
+			Name:   "bhyve-overcommit",
+			Usage:  "What to do when the host lacks memory or CPUs for the VM: refuse, warn or allow",
+			EnvVar: "BHYVE_OVERCOMMIT",
+			Value:  defaultOvercommit,
+		},
+		mcnflag.BoolFlag{
+			Name:   "bhyve-wire-memory",
+			Usage:  "Wire guest memory",
+			EnvVar: "BHYVE_WIRE_MEMORY",
+		},
+		mcnflag.BoolFlag{
+			Name:   "bhyve-no-hlt-exit",
+			Usage:  "Don't exit to the host when a vCPU executes HLT",
+			EnvVar: "BHYVE_NO_HLT_EXIT",
+		},
+		mcnflag.BoolFlag{
+			Name:   "bhyve-no-pause-exit",
+			Usage:  "Don't exit to the host when a vCPU executes PAUSE",
+			EnvVar: "BHYVE_NO_PAUSE_EXIT",
+		},
+		mcnflag.IntFlag{
+			Name:   "bhyve-cpu-sockets",
+			Usage:  "Number of CPU sockets in VM, requires cores and threads",
+			EnvVar: "BHYVE_CPU_SOCKETS",
+		},
+		mcnflag.IntFlag{
+			Name:   "bhyve-cpu-cores",
+			Usage:  "Number of cores per CPU socket in VM",
+			EnvVar: "BHYVE_CPU_CORES",
+		},
+		mcnflag.IntFlag{
+			Name:   "bhyve-cpu-threads",
+			Usage:  "Number of threads per CPU core in VM",
+			EnvVar: "BHYVE_CPU_THREADS",
+		},
+		mcnflag.StringFlag{
+			Name:   "bhyve-cpu-pin",
+			Usage:  "Pin vCPUs to host CPUs: auto, or vcpu:hostcpu[,vcpu:hostcpu...]",
+			EnvVar: "BHYVE_CPU_PIN",
+		},
+		mcnflag.StringFlag{
+			Name:   "bhyve-rctl-memoryuse",
+			Usage:  "Limit memory use of the bhyve process, e.g. 2g",
+			EnvVar: "BHYVE_RCTL_MEMORYUSE",
+		},
+		mcnflag.StringFlag{
+			Name:   "bhyve-rctl-readbps",
+			Usage:  "Throttle disk reads of the bhyve process to bytes per second, e.g. 50m",
+			EnvVar: "BHYVE_RCTL_READBPS",
+		},
+		mcnflag.StringFlag{
+			Name:   "bhyve-rctl-writebps",
+			Usage:  "Throttle disk writes of the bhyve process to bytes per second, e.g. 50m",
+			EnvVar: "BHYVE_RCTL_WRITEBPS",
+		},
+		mcnflag.StringFlag{
+			Name:   "bhyve-rctl-readiops",
+			Usage:  "Throttle disk reads of the bhyve process to operations per second",
+			EnvVar: "BHYVE_RCTL_READIOPS",
+		},
+		mcnflag.StringFlag{
+			Name:   "bhyve-rctl-writeiops",
+			Usage:  "Throttle disk writes of the bhyve process to operations per second",
+			EnvVar: "BHYVE_RCTL_WRITEIOPS",
+		},
+		mcnflag.StringFlag{
+			Name:   "bhyve-rctl-pcpu",
+			Usage:  "Limit CPU use of the bhyve process in percent of a single CPU",
+			EnvVar: "BHYVE_RCTL_PCPU",
+		},
+		mcnflag.StringSliceFlag{
+			Name:   "bhyve-passthru",
+			Usage:  "Pass host PCI device bus/slot/function through to the VM, may be repeated; implies --bhyve-wire-memory",
+			EnvVar: "BHYVE_PASSTHRU",
+			Value:  []string{},
+		},
+		mcnflag.BoolFlag{
+			Name:   "bhyve-vnc",
+			Usage:  "Add a framebuffer with VNC console and a tablet input device",
+			EnvVar: "BHYVE_VNC",
+		},
+		mcnflag.StringFlag{
+			Name:   "bhyve-vnc-resolution",
+			Usage:  "Framebuffer resolution as <width>x<height>",
+			EnvVar: "BHYVE_VNC_RESOLUTION",
+			Value:  defaultVNCResolution,
+		},
+		mcnflag.StringFlag{
+			Name:   "bhyve-vnc-bind",
+			Usage:  "Address the VNC console listens on",
+			EnvVar: "BHYVE_VNC_BIND",
+			Value:  defaultVNCBind,
+		},
+		mcnflag.IntFlag{
+			Name:   "bhyve-vnc-port",
+			Usage:  "Port the VNC console listens on, 0 to pick a free one",
+			EnvVar: "BHYVE_VNC_PORT",
+		},
+		mcnflag.StringFlag{
+			Name:   "bhyve-vnc-password",
+			Usage:  "Password for the VNC console",
+			EnvVar: "BHYVE_VNC_PASSWORD",
+		},
+		mcnflag.StringSliceFlag{
+			Name:   "bhyve-share",
+			Usage:  "Share host directory into the VM as hostpath:tag[:guestpath][:ro], at the host path by default, may be repeated",
+			EnvVar: "BHYVE_SHARE",
+			Value:  []string{},
+		},
+		mcnflag.StringFlag{
+			Name:   "bhyve-share-type",
+			Usage:  "How to share host directories: 9p, or nfs for guests without 9p support",
+			EnvVar: "BHYVE_SHARE_TYPE",
+			Value:  defaultShareType,
+		},
+		mcnflag.StringSliceFlag{
+			Name:   "bhyve-console-port",
+			Usage:  "Add a virtio-console port with this name, backed by a UNIX socket in the machine directory, may be repeated",
+			EnvVar: "BHYVE_CONSOLE_PORT",
+			Value:  []string{},
+		},
+		mcnflag.StringFlag{
+			Name:   "bhyve-ip-discovery",
+			Usage:  "How to find the VM's IP: lease (dnsmasq leases), arp (host ARP table) or guest (reported by the guest)",
+			EnvVar: "BHYVE_IP_DISCOVERY",
+			Value:  defaultIPDiscovery,
+		},
+		mcnflag.BoolFlag{
+			Name:   "bhyve-rtc-utc",
+			Usage:  "Keep the VM's RTC in UTC instead of local time",
+			EnvVar: "BHYVE_RTC_UTC",
+		},
+		mcnflag.IntFlag{
+			Name:   "bhyve-clock-drift-threshold",
+			Usage:  "Warn when the VM's clock drifted more than this many seconds",
+			EnvVar: "BHYVE_CLOCK_DRIFT_THRESHOLD",
+			Value:  defaultClockDrift,
+		},
+		mcnflag.IntFlag{
+			Name:   "bhyve-clock-sync-interval",
+			Usage:  "Resync the VM's clock every this many seconds, 0 to only sync on start",
+			EnvVar: "BHYVE_CLOCK_SYNC_INTERVAL",
+			Value:  defaultClockSync,
+		},
 	}
 }
 
@@ -135,8 +374,7 @@ func (d *Driver) GetIP() (string, error) {
 		return d.IPAddress, nil
 	}
 
-	log.Debugf("getting IP from DHCP lease")
-	ip, err := getIPfromDHCPLease(filepath.Join(d.StorePath, "bhyve.leases"), d.MACAddress)
+	ip, err := d.discoverIP()
 	if err != nil {
 		return "", err
 	}
@@ -150,9 +388,17 @@ func (d *Driver) GetSSHHostname() (string, error) {
 
 func (d *Driver) GetState() (state.State, error) {
 	if fileExists("/dev/vmm/" + d.BhyveVMName) {
+		if d.Paused {
+			log.Debugf("STATE: paused")
+			return state.Paused, nil
+		}
 		log.Debugf("STATE: running")
 		return state.Running, nil
 	}
+	if fileExists(d.checkpointPath()) {
+		log.Debugf("STATE: saved")
+		return state.Saved, nil
+	}
 	return state.Stopped, nil
 }
 
@@ -165,6 +411,38 @@ func (d *Driver) GetURL() (string, error) {
 }
 
 func (d *Driver) Kill() error {
+	if err := d.teardown(); err != nil {
+		return err
+	}
+
+	// a killed machine boots fresh instead of resuming a saved state
+	if err := d.removeCheckpoint(); err != nil {
+		return err
+	}
+	d.Paused = false
+
+	return nil
+}
+
+// teardown destroys the VM and the host resources it was using.
+func (d *Driver) teardown() error {
+	if len(d.ResourceLimits) > 0 {
+		if err := removeResourceLimits(d.BhyveVMName); err != nil {
+			return err
+		}
+	}
+
+	// a frozen bhyve would never notice its VM is gone and keep the taps
+	// and guest memory, let it run into the destroyed VM and exit
+	if d.Paused {
+		if pid, err := findBhyvePID(d.BhyveVMName); err == nil {
+			if err := easyCmd("sudo", "kill", "-CONT", strconv.Itoa(pid)); err != nil {
+				return err
+			}
+		}
+		d.Paused = false
+	}
+
 	if err := destroyVM(d.BhyveVMName); err != nil {
 		return err
 	}
@@ -175,6 +453,26 @@ func (d *Driver) Kill() error {
 		}
 	}
 
+	for i := range d.Networks {
+		if d.Networks[i].NetDev == "" {
+			continue
+		}
+		if err := destroyTap(d.Networks[i].NetDev); err != nil {
+			return err
+		}
+		d.Networks[i].NetDev = ""
+	}
+
+	if len(d.Shares) > 0 && d.ShareType == shareTypeNFS {
+		if err := d.unexportShares(); err != nil {
+			return err
+		}
+	}
+
+	if err := d.stopClockSync(); err != nil {
+		return err
+	}
+
 	if err := killConsoleLogger(d.ResolveStorePath("nmdm.pid")); err != nil {
 		return err
 	}
@@ -202,6 +500,22 @@ func (d *Driver) PreCreateCheck() error {
 
 	d.BhyveVMName = "docker-machine-" + username.Username + "-" + d.MachineName
 
+	// checkHostResources applies the overcommit policy to the host's CPUs and memory
+	err = validateTopology(d.CPUcount, d.MemSize, d.CPUSockets, d.CPUCores, d.CPUThreads)
+	if err != nil {
+		return err
+	}
+
+	err = d.checkHostResources()
+	if err != nil {
+		return err
+	}
+
+	err = d.checkVMMCapabilities()
+	if err != nil {
+		return err
+	}
+
 	err = ensureIPForwardingEnabled()
 	if err != nil {
 		return err
@@ -216,6 +530,51 @@ func (d *Driver) PreCreateCheck() error {
 	if err != nil {
 		return err
 	}
+
+	for _, nic := range d.Networks {
+		err = ensureBridge(nic.Bridge)
+		if err != nil {
+			return err
+		}
+	}
+
+	if dataset, ok := zfsDataset(d.Storage); ok {
+		err = checkZFSDataset(dataset)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = checkResourceLimits(d.ResourceLimits)
+	if err != nil {
+		return err
+	}
+
+	err = checkPassthru(d.Passthru)
+	if err != nil {
+		return err
+	}
+
+	if len(d.Shares) > 0 && d.ShareType == shareTypeNFS {
+		err = checkNFSServer()
+		if err != nil {
+			return err
+		}
+	}
+
+	if d.CPUPin != "" && d.CPUPin != cpuPinAuto {
+		_, err = d.cpuPins()
+		if err != nil {
+			return err
+		}
+	}
+
+	if d.CloneFrom != "" {
+		_, err = LoadDriver(d.StorePath, d.CloneFrom)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -225,7 +584,27 @@ func (d *Driver) Remove() error {
 		log.Debugf("Failed to kill %s, perhaps already dead?", d.MachineName)
 	}
 
-	err = os.RemoveAll(d.ResolveStorePath(diskname))
+	if d.ZVol != "" {
+		err = destroyZVol(d.ZVol)
+		if err == nil && d.ZVolOrigin != "" {
+			// the clone is gone, so the template's snapshot can go too
+			err = destroyZVol(d.ZVolOrigin)
+		}
+	} else {
+		err = os.RemoveAll(d.ResolveStorePath(diskname))
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, disk := range d.ExtraDisks {
+		err = os.RemoveAll(d.ResolveStorePath(disk.Filename))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = releaseCPUs(cpuPinStatePath, d.BhyveVMName)
 	if err != nil {
 		return err
 	}
@@ -259,6 +638,117 @@ func (d *Driver) SetConfigFromFlags(flags drivers.DriverOptions) error {
 	d.DHCPRange = string(flags.String("bhyve-dhcprange"))
 	d.Boot2DockerURL = flags.String("bhyve-boot2docker-url")
 
+	d.Networks = nil
+	for _, spec := range flags.StringSlice("bhyve-network") {
+		nic, err := parseNetworkInterface(spec)
+		if err != nil {
+			return err
+		}
+		d.Networks = append(d.Networks, nic)
+	}
+
+	d.Storage = flags.String("bhyve-storage")
+	if _, ok := zfsDataset(d.Storage); !ok && d.Storage != defaultStorage {
+		return fmt.Errorf("invalid storage %q, must be file or zfs:<pool/dataset>", d.Storage)
+	}
+
+	d.CloneFrom = flags.String("bhyve-clone-from")
+	d.WireMemory = flags.Bool("bhyve-wire-memory")
+	d.NoHLTExit = flags.Bool("bhyve-no-hlt-exit")
+	d.NoPauseExit = flags.Bool("bhyve-no-pause-exit")
+	d.CPUSockets = flags.Int("bhyve-cpu-sockets")
+	d.CPUCores = flags.Int("bhyve-cpu-cores")
+	d.CPUThreads = flags.Int("bhyve-cpu-threads")
+
+	d.Passthru = nil
+	for _, spec := range flags.StringSlice("bhyve-passthru") {
+		if err := parsePassthru(spec); err != nil {
+			return err
+		}
+		d.Passthru = append(d.Passthru, spec)
+	}
+	if len(d.Passthru) > 0 {
+		// bhyve requires wired memory for passthrough devices
+		d.WireMemory = true
+	}
+
+	d.VNC = flags.Bool("bhyve-vnc")
+	d.VNCResolution = flags.String("bhyve-vnc-resolution")
+	d.VNCBind = flags.String("bhyve-vnc-bind")
+	d.VNCPort = flags.Int("bhyve-vnc-port")
+	d.VNCPassword = flags.String("bhyve-vnc-password")
+	if d.VNC {
+		if _, _, err := parseVNCResolution(d.VNCResolution); err != nil {
+			return err
+		}
+		if net.ParseIP(d.VNCBind) == nil {
+			return fmt.Errorf("invalid VNC bind address %q", d.VNCBind)
+		}
+	}
+
+	d.Shares = nil
+	for _, spec := range flags.StringSlice("bhyve-share") {
+		share, err := parseShare(spec)
+		if err != nil {
+			return err
+		}
+		d.Shares = append(d.Shares, share)
+	}
+
+	d.ShareType = flags.String("bhyve-share-type")
+	if d.ShareType != shareType9p && d.ShareType != shareTypeNFS {
+		return fmt.Errorf("invalid share type %q, must be 9p or nfs", d.ShareType)
+	}
+
+	d.ConsolePorts = nil
+	for _, name := range flags.StringSlice("bhyve-console-port") {
+		if err := checkConsolePortName(name); err != nil {
+			return err
+		}
+		d.ConsolePorts = append(d.ConsolePorts, name)
+	}
+
+	d.IPDiscovery = flags.String("bhyve-ip-discovery")
+	switch d.IPDiscovery {
+	case ipDiscoveryLease, ipDiscoveryARP, ipDiscoveryGuest:
+	default:
+		return fmt.Errorf("invalid IP discovery %q, must be lease, arp or guest", d.IPDiscovery)
+	}
+
+	d.RTCUTC = flags.Bool("bhyve-rtc-utc")
+	d.ClockDriftThreshold = flags.Int("bhyve-clock-drift-threshold")
+	d.ClockSyncInterval = flags.Int("bhyve-clock-sync-interval")
+
+	d.ResourceLimits = map[string]string{}
+	for resource := range rctlResources {
+		if amount := flags.String("bhyve-rctl-" + resource); amount != "" {
+			d.ResourceLimits[resource] = amount
+		}
+	}
+
+	d.CPUPin = flags.String("bhyve-cpu-pin")
+	if d.CPUPin != "" && d.CPUPin != cpuPinAuto {
+		if _, err := parseCPUPins(d.CPUPin, d.CPUcount); err != nil {
+			return err
+		}
+	}
+
+	d.Overcommit = flags.String("bhyve-overcommit")
+	switch d.Overcommit {
+	case overcommitRefuse, overcommitWarn, overcommitAllow:
+	default:
+		return fmt.Errorf("invalid overcommit policy %q, must be refuse, warn or allow", d.Overcommit)
+	}
+
+	d.ExtraDisks = nil
+	for i, spec := range flags.StringSlice("bhyve-extra-disk") {
+		disk, err := parseExtraDisk(spec, "disk"+strconv.Itoa(i+1)+".img")
+		if err != nil {
+			return err
+		}
+		d.ExtraDisks = append(d.ExtraDisks, disk)
+	}
+
 	return nil
 }
 
@@ -267,16 +757,32 @@ func (d *Driver) Start() error {
 	bhyvelogpath := d.ResolveStorePath("bhyve.log")
 	log.Debugf("bhyvelogpath: %s", bhyvelogpath)
 
-	err := writeDeviceMap(d.ResolveStorePath("/device.map"), d.ResolveStorePath(isoFilename), d.ResolveStorePath(diskname))
+	err := d.checkHostResources()
 	if err != nil {
 		return err
 	}
 
-	err = runGrub(d.ResolveStorePath("/device.map"), strconv.Itoa(int(d.MemSize)), d.BhyveVMName)
+	err = d.recoverStaleState()
 	if err != nil {
 		return err
 	}
 
+	checkpoint := d.checkpointPath()
+	restore := fileExists(checkpoint)
+	if restore {
+		log.Infof("Restoring %s from saved state...", d.MachineName)
+	} else {
+		err = writeDeviceMap(d.ResolveStorePath("/device.map"), d.ResolveStorePath(isoFilename), d.diskPath())
+		if err != nil {
+			return err
+		}
+
+		err = runGrub(d.ResolveStorePath("/device.map"), strconv.Itoa(int(d.MemSize)), d.WireMemory, d.BhyveVMName)
+		if err != nil {
+			return err
+		}
+	}
+
 	nmdmdev, err := findNMDMDev()
 	if err != nil {
 		return err
@@ -289,19 +795,55 @@ func (d *Driver) Start() error {
 	}
 	d.NetDev = tapdev
 
-	cdpath := d.ResolveStorePath(isoFilename)
-	cpucount := strconv.Itoa(int(d.CPUcount))
-	ram := strconv.Itoa(int(d.MemSize))
+	for i := range d.Networks {
+		nictap, err := findtapdev(d.Networks[i].Bridge)
+		if err != nil {
+			return err
+		}
+		d.Networks[i].NetDev = nictap
+	}
+
+	if d.VNC && d.VNCPort == 0 {
+		d.VNCPort, err = d.allocateVNCPort()
+		if err != nil {
+			return err
+		}
+	}
+
+	hw, err := d.buildHardware(nmdmdev)
+	if err != nil {
+		return err
+	}
+
+	// a restored guest doesn't boot again, its old output is still current
+	if !restore {
+		err = rotateConsoleLog(d.ResolveStorePath(""))
+		if err != nil {
+			return err
+		}
+	}
 
 	err = startConsoleLogger(d.ResolveStorePath(""), nmdmdev)
 	if err != nil {
 		return err
 	}
 
-	cmd := exec.Command("/usr/sbin/daemon", "-t", "XXXXX", "-f", "sudo", "bhyve", "-A", "-H", "-P", "-s",
-		"0:0,hostbridge", "-s", "1:0,lpc", "-s", "2:0,virtio-net,"+tapdev+",mac="+d.MACAddress, "-s", "3:0,virtio-blk,"+
-			d.ResolveStorePath(diskname), "-s", "4:0,virtio-rnd,/dev/random", "-s", "5:0,ahci-cd,"+cdpath, "-l", "com1,"+nmdmdev+"A", "-c", cpucount, "-m", ram+"M",
-		d.BhyveVMName)
+	bhyveconf := d.ResolveStorePath("bhyve.conf")
+	err = writeBhyveConfig(bhyveconf, hw)
+	if err != nil {
+		return err
+	}
+	log.Debugf("bhyve config %s is equivalent to: bhyve %s", bhyveconf, strings.Join(hw.logArgs(), " "))
+
+	// a machine frozen before a host reboot isn't frozen any more
+	d.Paused = false
+
+	cmdargs := []string{"-t", "XXXXX", "-f", "sudo", "bhyve", "-k", bhyveconf}
+	if restore {
+		cmdargs = append(cmdargs, "-r", checkpoint)
+	}
+
+	cmd := exec.Command("/usr/sbin/daemon", cmdargs...)
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
 		return err
@@ -319,7 +861,15 @@ func (d *Driver) Start() error {
 	}
 	log.Debugf("bhyve: " + stripCtlAndExtFromBytes(string(slurp)))
 
-	ip, err := waitForIP(d.StorePath, d.MACAddress)
+	if err := applyResourceLimits(d.BhyveVMName, d.ResourceLimits); err != nil {
+		return err
+	}
+
+	if err := d.fixConsolePortOwner(); err != nil {
+		return err
+	}
+
+	ip, err := waitForIP(d.discoverIP)
 	if err != nil {
 		return err
 	}
@@ -330,6 +880,40 @@ func (d *Driver) Start() error {
 		return err
 	}
 
+	if restore {
+		if err := d.removeCheckpoint(); err != nil {
+			return err
+		}
+	}
+
+	if err := d.SyncClock(); err != nil {
+		log.Warnf("Couldn't sync clock of %s: %s", d.MachineName, err)
+	}
+
+	if err := d.startClockSync(); err != nil {
+		return err
+	}
+
+	// a restored guest still has its shares mounted and its filesystem
+	// grown, it only needs the NFS exports for its address back
+	if restore {
+		if d.ShareType == shareTypeNFS && len(d.Shares) > 0 {
+			return d.exportShares(d.IPAddress)
+		}
+		return nil
+	}
+
+	if err := d.mountShares(); err != nil {
+		return err
+	}
+
+	if d.GrowDataPartition {
+		if err := d.growDataFilesystem(); err != nil {
+			return err
+		}
+		d.GrowDataPartition = false
+	}
+
 	return nil
 }
 
@@ -350,14 +934,22 @@ func NewDriver(hostName, storePath string) *Driver {
 			MachineName: hostName,
 			StorePath:   storePath,
 		},
-		DiskSize:       defaultDiskSize,
-		MemSize:        defaultMemSize,
-		CPUcount:       defaultCPUCount,
-		MACAddress:     "",
-		Bridge:         defaultBridge,
-		DHCPRange:      defaultHostOnlyCIDR,
-		Boot2DockerURL: defaultBoot2DockerURL,
-		Subnet:         defaultSubnet,
-		BhyveVMName:    defaultBhyveVMName,
+		DiskSize:            defaultDiskSize,
+		MemSize:             defaultMemSize,
+		CPUcount:            defaultCPUCount,
+		MACAddress:          "",
+		Bridge:              defaultBridge,
+		DHCPRange:           defaultHostOnlyCIDR,
+		Boot2DockerURL:      defaultBoot2DockerURL,
+		Subnet:              defaultSubnet,
+		BhyveVMName:         defaultBhyveVMName,
+		Storage:             defaultStorage,
+		Overcommit:          defaultOvercommit,
+		VNCResolution:       defaultVNCResolution,
+		VNCBind:             defaultVNCBind,
+		ShareType:           defaultShareType,
+		IPDiscovery:         defaultIPDiscovery,
+		ClockDriftThreshold: defaultClockDrift,
+		ClockSyncInterval:   defaultClockSync,
 	}
 }
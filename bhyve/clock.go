@@ -0,0 +1,93 @@
+// Copyright 2019 Steve Wills. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bhyve
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	clockSyncPidFilename = "clocksync.pid"
+	ctlCommand           = "docker-machine-bhyve-ctl"
+)
+
+// SyncClock sets the guest clock to the host clock over SSH, logging a
+// warning if the guest drifted by more than ClockDriftThreshold seconds.
+func (d *Driver) SyncClock() error {
+	out, err := drivers.RunSSHCommandFromDriver(d, "date -u +%s")
+	if err != nil {
+		return err
+	}
+	guest, err := strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+	if err != nil {
+		return fmt.Errorf("couldn't parse guest time %q", out)
+	}
+
+	now := time.Now().UTC()
+	drift := now.Unix() - guest
+	if drift < 0 {
+		drift = -drift
+	}
+	if drift > int64(d.ClockDriftThreshold) {
+		log.Warnf("Clock of %s drifted by %d seconds, resyncing", d.MachineName, drift)
+	} else {
+		log.Debugf("Clock of %s drifted by %d seconds", d.MachineName, drift)
+	}
+	if drift == 0 {
+		return nil
+	}
+
+	_, err = drivers.RunSSHCommandFromDriver(d, "sudo date -u -s '"+now.Format("2006-01-02 15:04:05")+"'")
+	return err
+}
+
+func (d *Driver) startClockSync() error {
+	if d.ClockSyncInterval <= 0 {
+		return nil
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(os.Args[0]))
+	if err != nil {
+		return err
+	}
+
+	return easyCmd("/usr/sbin/daemon", "-f", "-p", d.ResolveStorePath(clockSyncPidFilename),
+		filepath.Join(dir, ctlCommand), "-s", d.StorePath, "clocksync", d.MachineName)
+}
+
+func (d *Driver) stopClockSync() error {
+	pidfile := d.ResolveStorePath(clockSyncPidFilename)
+	running, err := removeStalePIDFile(pidfile, ctlCommand)
+	if err != nil || !running {
+		return err
+	}
+
+	data, err := ioutil.ReadFile(pidfile)
+	if err != nil {
+		return err
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return err
+	}
+	if process, err := os.FindProcess(pid); err == nil {
+		if err := process.Signal(syscall.SIGTERM); err != nil {
+			log.Debugf("Couldn't stop clock sync process %d: %s", pid, err)
+		}
+	}
+
+	return os.Remove(pidfile)
+}
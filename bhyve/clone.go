@@ -0,0 +1,107 @@
+// Copyright 2019 Steve Wills. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bhyve
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/ssh"
+)
+
+// createFromClone creates the machine from the disks of the stopped machine
+// d.CloneFrom instead of a freshly formatted disk. The clone boots with the
+// template's SSH key once, and its own newly generated key is installed
+// before returning.
+func (d *Driver) createFromClone() error {
+	src, err := LoadDriver(d.StorePath, d.CloneFrom)
+	if err != nil {
+		return err
+	}
+	if err := src.checkStopped(); err != nil {
+		return err
+	}
+
+	log.Infof("Cloning %s from %s...", d.MachineName, src.MachineName)
+	if _, err := copyFile(src.ResolveStorePath(isoFilename), d.ResolveStorePath(isoFilename)); err != nil {
+		return err
+	}
+
+	dataset, zfs := zfsDataset(d.Storage)
+	if (src.ZVol != "") != zfs {
+		return fmt.Errorf("%s and %s must use the same storage backend", src.MachineName, d.MachineName)
+	}
+	if zfs {
+		d.ZVol = dataset + "/" + d.BhyveVMName
+		d.ZVolOrigin, err = cloneZVol(src.ZVol, d.ZVol)
+		if err != nil {
+			return err
+		}
+	} else {
+		if err := cloneFile(src.ResolveStorePath(diskname), d.ResolveStorePath(diskname)); err != nil {
+			return err
+		}
+	}
+	d.DiskSize = src.DiskSize
+
+	d.ExtraDisks = src.ExtraDisks
+	for _, disk := range d.ExtraDisks {
+		if err := cloneFile(src.ResolveStorePath(disk.Filename), d.ResolveStorePath(disk.Filename)); err != nil {
+			return err
+		}
+	}
+
+	keypath := d.GetSSHKeyPath()
+	log.Infof("Creating SSH key...")
+	if err := ssh.GenerateSSHKey(keypath); err != nil {
+		return err
+	}
+
+	d.SSHKeyPath = src.GetSSHKeyPath()
+	defer func() {
+		d.SSHKeyPath = keypath
+	}()
+
+	log.Infof("Starting %s...", d.MachineName)
+	if err := d.Start(); err != nil {
+		return err
+	}
+
+	return d.installSSHKey(keypath + ".pub")
+}
+
+// cloneZVol clones src to dst and returns the origin snapshot of the clone.
+func cloneZVol(src string, dst string) (string, error) {
+	snapshot := src + "@clone-" + strings.Replace(dst, "/", "-", -1)
+	if err := easyCmd("sudo", "zfs", "snapshot", snapshot); err != nil {
+		return "", err
+	}
+
+	if err := easyCmd("sudo", "zfs", "clone", "-o", "volmode=dev", snapshot, dst); err != nil {
+		return "", err
+	}
+
+	return snapshot, nil
+}
+
+// installSSHKey replaces the authorized keys of the docker user and the
+// boot2docker userdata.tar that restores them on every boot.
+func (d *Driver) installSSHKey(pubkeypath string) error {
+	pubKey, err := ioutil.ReadFile(pubkeypath)
+	if err != nil {
+		return err
+	}
+
+	cmd := fmt.Sprintf("echo '%s' > ~/.ssh/authorized_keys && cp ~/.ssh/authorized_keys ~/.ssh/authorized_keys2 && "+
+		"tar cf /tmp/userdata.tar .ssh && sudo mv /tmp/userdata.tar /var/lib/boot2docker/userdata.tar",
+		strings.TrimSpace(string(pubKey)))
+	out, err := drivers.RunSSHCommandFromDriver(d, cmd)
+	log.Debugf("install SSH key: %s", out)
+
+	return err
+}
@@ -0,0 +1,127 @@
+// Copyright 2019 Steve Wills. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bhyve
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// maximum length of a UNIX socket path, see unix(4)
+const maxSocketPath = 104
+
+var consolePortRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// ConsolePort is a connection to a named virtio-console port of a running
+// machine. Messages are exchanged as lines of text.
+type ConsolePort struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func checkConsolePortName(name string) error {
+	if !consolePortRegexp.MatchString(name) {
+		return fmt.Errorf("invalid console port name %q", name)
+	}
+	return nil
+}
+
+func (d *Driver) consolePortPath(name string) string {
+	return d.ResolveStorePath(name + ".sock")
+}
+
+func (d *Driver) consolePortOptions() ([]string, error) {
+	options := []string{}
+	for _, name := range d.ConsolePorts {
+		path := d.consolePortPath(name)
+		if len(path) >= maxSocketPath {
+			return nil, fmt.Errorf("socket path %s for console port %s is too long", path, name)
+		}
+		options = append(options, name+"="+path)
+	}
+	return options, nil
+}
+
+// fixConsolePortOwner hands the sockets bhyve created as root to the user
+// running the driver.
+func (d *Driver) fixConsolePortOwner() error {
+	for _, name := range d.ConsolePorts {
+		path := d.consolePortPath(name)
+		for tries := 0; !fileExists(path); tries++ {
+			if tries > retrycount {
+				return fmt.Errorf("console port socket %s did not appear", path)
+			}
+			time.Sleep(sleeptime * time.Millisecond)
+		}
+		if err := easyCmd("sudo", "chown", strconv.Itoa(os.Getuid()), path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ConsolePort connects to the virtio-console port name of the running
+// machine.
+func (d *Driver) ConsolePort(name string) (*ConsolePort, error) {
+	found := false
+	for _, port := range d.ConsolePorts {
+		if port == name {
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("machine %s has no console port %s", d.MachineName, name)
+	}
+
+	conn, err := net.Dial("unix", d.consolePortPath(name))
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Connected to console port %s of %s", name, d.MachineName)
+
+	return &ConsolePort{
+		conn:   conn,
+		reader: bufio.NewReader(conn),
+	}, nil
+}
+
+// Send writes msg as a single line to the guest.
+func (c *ConsolePort) Send(msg string) error {
+	if strings.Contains(msg, "\n") {
+		return fmt.Errorf("message must not contain newlines")
+	}
+	_, err := c.conn.Write([]byte(msg + "\n"))
+	return err
+}
+
+// Receive waits up to timeout for a line from the guest, zero meaning no
+// timeout.
+func (c *ConsolePort) Receive(timeout time.Duration) (string, error) {
+	deadline := time.Time{}
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if err := c.conn.SetReadDeadline(deadline); err != nil {
+		return "", err
+	}
+
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (c *ConsolePort) Close() error {
+	return c.conn.Close()
+}
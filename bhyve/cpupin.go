@@ -0,0 +1,186 @@
+// Copyright 2019 Steve Wills. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bhyve
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	cpuPinAuto = "auto"
+	// shared by all users and stores on the host, so automatic pinning
+	// sees every VM
+	cpuPinStateDir  = "/var/db/docker-machine-driver-bhyve"
+	cpuPinStatePath = cpuPinStateDir + "/cpupin.json"
+)
+
+// parseCPUPins parses an explicit "vcpu:hostcpu,..." pinning.
+func parseCPUPins(spec string, cpus int) (map[int]int, error) {
+	pins := map[int]int{}
+	for _, pin := range strings.Split(spec, ",") {
+		parts := strings.Split(pin, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid CPU pin %q, must be vcpu:hostcpu", pin)
+		}
+		vcpu, err := strconv.Atoi(parts[0])
+		if err != nil || vcpu < 0 || vcpu >= cpus {
+			return nil, fmt.Errorf("invalid vCPU %q in CPU pin %q", parts[0], pin)
+		}
+		hostcpu, err := strconv.Atoi(parts[1])
+		if err != nil || hostcpu < 0 {
+			return nil, fmt.Errorf("invalid host CPU %q in CPU pin %q", parts[1], pin)
+		}
+		pins[vcpu] = hostcpu
+	}
+	return pins, nil
+}
+
+// cpuPins returns the vCPU to host CPU pinning for d, allocating host CPUs
+// if pinning is automatic.
+func (d *Driver) cpuPins() (map[int]int, error) {
+	if d.CPUPin == "" {
+		return nil, nil
+	}
+
+	ncpu, err := sysctlInt("hw.ncpu")
+	if err != nil {
+		return nil, err
+	}
+
+	if d.CPUPin == cpuPinAuto {
+		return allocateCPUs(cpuPinStatePath, d.BhyveVMName, d.CPUcount, int(ncpu))
+	}
+
+	pins, err := parseCPUPins(d.CPUPin, d.CPUcount)
+	if err != nil {
+		return nil, err
+	}
+	for _, hostcpu := range pins {
+		if int64(hostcpu) >= ncpu {
+			return nil, fmt.Errorf("can't pin to host CPU %d, host only has %d", hostcpu, ncpu)
+		}
+	}
+	return pins, nil
+}
+
+// ensureCPUPinState creates the host-wide state file. It is owned by root
+// and only written through sudo, so users can't change each other's pinning.
+func ensureCPUPinState(statefile string) error {
+	if fileExists(statefile) {
+		return nil
+	}
+
+	if err := easyCmd("sudo", "mkdir", "-p", filepath.Dir(statefile)); err != nil {
+		return err
+	}
+	return easyCmd("sudo", "touch", statefile)
+}
+
+// updateCPUPinState runs update on the host CPU assignments of all VMs,
+// holding an exclusive lock on the state file.
+func updateCPUPinState(statefile string, update func(assignments map[string][]int)) error {
+	if err := ensureCPUPinState(statefile); err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(statefile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	data, err := ioutil.ReadFile(statefile)
+	if err != nil {
+		return err
+	}
+	assignments := map[string][]int{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &assignments); err != nil {
+			log.Warnf("Ignoring unreadable CPU pinning state %s: %s", statefile, err)
+			assignments = map[string][]int{}
+		}
+	}
+
+	update(assignments)
+
+	data, err = json.MarshalIndent(assignments, "", "    ")
+	if err != nil {
+		return err
+	}
+	return writeFileAsRoot(statefile, data)
+}
+
+// assignCPUs spreads cpus vCPUs of vmname over the least used of ncpu host
+// CPUs, keeping an earlier assignment of the same size, and records it.
+func assignCPUs(assignments map[string][]int, vmname string, cpus int, ncpu int) map[int]int {
+	pins := map[int]int{}
+
+	if current, ok := assignments[vmname]; ok && len(current) == cpus {
+		for vcpu, hostcpu := range current {
+			pins[vcpu] = hostcpu
+		}
+		return pins
+	}
+
+	load := make([]int, ncpu)
+	for name, hostcpus := range assignments {
+		if name == vmname {
+			continue
+		}
+		for _, hostcpu := range hostcpus {
+			if hostcpu < ncpu {
+				load[hostcpu]++
+			}
+		}
+	}
+
+	hostcpus := make([]int, cpus)
+	for vcpu := 0; vcpu < cpus; vcpu++ {
+		best := 0
+		for hostcpu := 1; hostcpu < ncpu; hostcpu++ {
+			if load[hostcpu] < load[best] {
+				best = hostcpu
+			}
+		}
+		load[best]++
+		hostcpus[vcpu] = best
+		pins[vcpu] = best
+	}
+	assignments[vmname] = hostcpus
+
+	return pins
+}
+
+// allocateCPUs spreads the vCPUs of vmname over the least used host CPUs and
+// remembers the assignment, so the VM keeps its CPUs across restarts.
+func allocateCPUs(statefile string, vmname string, cpus int, ncpu int) (map[int]int, error) {
+	var pins map[int]int
+	err := updateCPUPinState(statefile, func(assignments map[string][]int) {
+		pins = assignCPUs(assignments, vmname, cpus, ncpu)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugf("CPU pinning for %s: %v", vmname, pins)
+	return pins, nil
+}
+
+func releaseCPUs(statefile string, vmname string) error {
+	if !fileExists(statefile) {
+		return nil
+	}
+
+	return updateCPUPinState(statefile, func(assignments map[string][]int) {
+		delete(assignments, vmname)
+	})
+}
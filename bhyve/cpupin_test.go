@@ -0,0 +1,73 @@
+// Copyright 2019 Steve Wills. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bhyve
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAssignCPUs(t *testing.T) {
+	tests := []struct {
+		name        string
+		assignments map[string][]int
+		cpus        int
+		ncpu        int
+		pins        map[int]int
+	}{
+		{
+			name:        "empty host",
+			assignments: map[string][]int{},
+			cpus:        2,
+			ncpu:        4,
+			pins:        map[int]int{0: 0, 1: 1},
+		},
+		{
+			name:        "least used CPUs",
+			assignments: map[string][]int{"other": {0, 1}},
+			cpus:        2,
+			ncpu:        4,
+			pins:        map[int]int{0: 2, 1: 3},
+		},
+		{
+			name:        "spread when full",
+			assignments: map[string][]int{"a": {0, 1}, "b": {1, 2}},
+			cpus:        3,
+			ncpu:        3,
+			pins:        map[int]int{0: 0, 1: 2, 2: 0},
+		},
+		{
+			name:        "keeps earlier assignment",
+			assignments: map[string][]int{"vm": {3, 1}},
+			cpus:        2,
+			ncpu:        4,
+			pins:        map[int]int{0: 3, 1: 1},
+		},
+		{
+			name:        "reassigns on CPU count change",
+			assignments: map[string][]int{"vm": {3}},
+			cpus:        2,
+			ncpu:        4,
+			pins:        map[int]int{0: 0, 1: 1},
+		},
+		{
+			name:        "ignores CPUs the host lost",
+			assignments: map[string][]int{"other": {7, 0}},
+			cpus:        1,
+			ncpu:        2,
+			pins:        map[int]int{0: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		pins := assignCPUs(tt.assignments, "vm", tt.cpus, tt.ncpu)
+		if !reflect.DeepEqual(pins, tt.pins) {
+			t.Errorf("%s: got %v, want %v", tt.name, pins, tt.pins)
+		}
+		if len(tt.assignments["vm"]) != tt.cpus {
+			t.Errorf("%s: assignment not recorded: %v", tt.name, tt.assignments)
+		}
+	}
+}
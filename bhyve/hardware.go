@@ -0,0 +1,367 @@
+// Copyright 2019 Steve Wills. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bhyve
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const maxPCISlot = 31
+
+var passwordOption = regexp.MustCompile(`((?:^|,)password=)[^,]*`)
+
+// pciSlot is a PCI bus:slot:function address inside the guest.
+type pciSlot struct {
+	Bus      int
+	Slot     int
+	Function int
+}
+
+func (s pciSlot) String() string {
+	return fmt.Sprintf("%d:%d:%d", s.Bus, s.Slot, s.Function)
+}
+
+// pciDevice is a single emulated or passed through PCI device. Backing is
+// the device specific backend (tap interface, disk path, ...) and Options
+// are extra "key=value" or bare flag options understood by bhyve.
+type pciDevice struct {
+	Kind    string
+	Backing string
+	Options []string
+	Slot    pciSlot
+}
+
+// vmHardware describes the virtual hardware of a bhyve VM and knows how to
+// serialize itself to a bhyve command line or a bhyve configuration file.
+type vmHardware struct {
+	Name    string
+	CPUs    int
+	Memory  int64 // Mb
+	Console string
+	Devices []pciDevice
+
+	Sockets int
+	Cores   int
+	Threads int
+
+	WireMemory bool
+	HLTExit    bool
+	PauseExit  bool
+	CPUPins    map[int]int
+	RTCUTC     bool
+
+	nextSlot int
+}
+
+func newVMHardware(name string, cpus int, memory int64) *vmHardware {
+	return &vmHardware{
+		Name:      name,
+		CPUs:      cpus,
+		Memory:    memory,
+		HLTExit:   true,
+		PauseExit: true,
+	}
+}
+
+// addDevice places a device on the next free PCI slot of bus 0.
+func (h *vmHardware) addDevice(kind string, backing string, options ...string) error {
+	if h.nextSlot > maxPCISlot {
+		return fmt.Errorf("no free PCI slot for %s device", kind)
+	}
+
+	h.Devices = append(h.Devices, pciDevice{
+		Kind:    kind,
+		Backing: backing,
+		Options: options,
+		Slot:    pciSlot{Bus: 0, Slot: h.nextSlot, Function: 0},
+	})
+	h.nextSlot++
+
+	return nil
+}
+
+func (p pciDevice) arg() string {
+	parts := []string{p.Slot.String(), p.Kind}
+	if p.Backing != "" {
+		parts = append(parts, p.Backing)
+	}
+	parts = append(parts, p.Options...)
+	return strings.Join(parts, ",")
+}
+
+// configValues returns the bhyve_config(5) variables for the device,
+// relative to its pci.<bus>.<slot>.<function> node.
+func (p pciDevice) configValues() [][2]string {
+	values := [][2]string{}
+
+	switch p.Kind {
+	case "ahci-cd", "ahci-hd":
+		values = append(values, [2]string{"device", "ahci"})
+		values = append(values, [2]string{"port.0.type", strings.TrimPrefix(p.Kind, "ahci-")})
+		values = append(values, [2]string{"port.0.path", p.Backing})
+	case "virtio-net", "e1000":
+		values = append(values, [2]string{"device", p.Kind})
+		values = append(values, [2]string{"backend", p.Backing})
+	case "passthru":
+		values = append(values, [2]string{"device", p.Kind})
+		bsf := strings.Split(p.Backing, "/")
+		if len(bsf) == 3 {
+			values = append(values, [2]string{"bus", bsf[0]})
+			values = append(values, [2]string{"slot", bsf[1]})
+			values = append(values, [2]string{"func", bsf[2]})
+		}
+	case "virtio-9p":
+		values = append(values, [2]string{"device", p.Kind})
+		share := strings.SplitN(p.Backing, "=", 2)
+		if len(share) == 2 {
+			values = append(values, [2]string{"sharename", share[0]})
+			values = append(values, [2]string{"path", share[1]})
+		}
+	case "virtio-console":
+		values = append(values, [2]string{"device", p.Kind})
+		for _, opt := range p.Options {
+			kv := strings.SplitN(opt, "=", 2)
+			if len(kv) == 2 {
+				values = append(values, [2]string{"port." + kv[0] + ".path", kv[1]})
+			}
+		}
+		return values
+	case "xhci":
+		values = append(values, [2]string{"device", p.Kind})
+		values = append(values, [2]string{"slot.1.device", p.Backing})
+	case "hostbridge", "lpc", "virtio-rnd":
+		values = append(values, [2]string{"device", p.Kind})
+	default:
+		values = append(values, [2]string{"device", p.Kind})
+		if p.Backing != "" {
+			values = append(values, [2]string{"path", p.Backing})
+		}
+	}
+
+	for _, opt := range p.Options {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) == 2 {
+			values = append(values, [2]string{kv[0], kv[1]})
+		} else {
+			values = append(values, [2]string{kv[0], "true"})
+		}
+	}
+
+	return values
+}
+
+// args returns the bhyve arguments for the VM, not including the bhyve
+// command itself.
+func (h *vmHardware) args() []string {
+	args := []string{"-A"}
+	if h.HLTExit {
+		args = append(args, "-H")
+	}
+	if h.PauseExit {
+		args = append(args, "-P")
+	}
+	if h.WireMemory {
+		args = append(args, "-S")
+	}
+	if h.RTCUTC {
+		args = append(args, "-u")
+	}
+	for _, vcpu := range h.pinnedVCPUs() {
+		args = append(args, "-p", fmt.Sprintf("%d:%d", vcpu, h.CPUPins[vcpu]))
+	}
+
+	for _, dev := range h.Devices {
+		args = append(args, "-s", dev.arg())
+	}
+
+	if h.Console != "" {
+		args = append(args, "-l", "com1,"+h.Console)
+	}
+
+	cpus := strconv.Itoa(h.CPUs)
+	if h.Sockets > 0 {
+		cpus += fmt.Sprintf(",sockets=%d,cores=%d,threads=%d", h.Sockets, h.Cores, h.Threads)
+	}
+
+	args = append(args, "-c", cpus, "-m", strconv.FormatInt(h.Memory, 10)+"M", h.Name)
+
+	return args
+}
+
+// logArgs returns args with device passwords masked, for logging.
+func (h *vmHardware) logArgs() []string {
+	args := h.args()
+	for i, arg := range args {
+		args[i] = passwordOption.ReplaceAllString(arg, "${1}********")
+	}
+	return args
+}
+
+func (h *vmHardware) pinnedVCPUs() []int {
+	vcpus := make([]int, 0, len(h.CPUPins))
+	for vcpu := range h.CPUPins {
+		vcpus = append(vcpus, vcpu)
+	}
+	sort.Ints(vcpus)
+	return vcpus
+}
+
+// config returns the VM as a bhyve_config(5) file.
+func (h *vmHardware) config() string {
+	values := map[string]string{
+		"name":                h.Name,
+		"cpus":                strconv.Itoa(h.CPUs),
+		"memory.size":         strconv.FormatInt(h.Memory, 10) + "M",
+		"acpi_tables":         "true",
+		"x86.vmexit_on_hlt":   strconv.FormatBool(h.HLTExit),
+		"x86.vmexit_on_pause": strconv.FormatBool(h.PauseExit),
+		"memory.wired":        strconv.FormatBool(h.WireMemory),
+		"rtc.use_localtime":   strconv.FormatBool(!h.RTCUTC),
+	}
+
+	if h.Sockets > 0 {
+		values["sockets"] = strconv.Itoa(h.Sockets)
+		values["cores"] = strconv.Itoa(h.Cores)
+		values["threads"] = strconv.Itoa(h.Threads)
+	}
+
+	for vcpu, hostcpu := range h.CPUPins {
+		values[fmt.Sprintf("vcpu.%d.cpuset", vcpu)] = strconv.Itoa(hostcpu)
+	}
+
+	if h.Console != "" {
+		values["lpc.com1.path"] = h.Console
+	}
+
+	for _, dev := range h.Devices {
+		prefix := fmt.Sprintf("pci.%d.%d.%d.", dev.Slot.Bus, dev.Slot.Slot, dev.Slot.Function)
+		for _, kv := range dev.configValues() {
+			values[prefix+kv[0]] = kv[1]
+		}
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k + "=" + values[k] + "\n")
+	}
+
+	return b.String()
+}
+
+func writeBhyveConfig(path string, hw *vmHardware) error {
+	log.Debugf("Writing bhyve config %s", path)
+	return ioutil.WriteFile(path, []byte(hw.config()), 0600)
+}
+
+func (d *Driver) buildHardware(nmdmdev string) (*vmHardware, error) {
+	hw := newVMHardware(d.BhyveVMName, d.CPUcount, d.MemSize)
+	hw.Console = nmdmdev + "A"
+	hw.Sockets = d.CPUSockets
+	hw.Cores = d.CPUCores
+	hw.Threads = d.CPUThreads
+	hw.WireMemory = d.WireMemory
+	hw.HLTExit = !d.NoHLTExit
+	hw.PauseExit = !d.NoPauseExit
+	hw.RTCUTC = d.RTCUTC
+
+	pins, err := d.cpuPins()
+	if err != nil {
+		return nil, err
+	}
+	hw.CPUPins = pins
+
+	if err := hw.addDevice("hostbridge", ""); err != nil {
+		return nil, err
+	}
+	if err := hw.addDevice("lpc", ""); err != nil {
+		return nil, err
+	}
+	if err := hw.addDevice("virtio-net", d.NetDev, "mac="+d.MACAddress); err != nil {
+		return nil, err
+	}
+	if err := hw.addDevice("virtio-blk", d.diskPath()); err != nil {
+		return nil, err
+	}
+	if err := hw.addDevice("virtio-rnd", "/dev/random"); err != nil {
+		return nil, err
+	}
+	if err := hw.addDevice("ahci-cd", d.ResolveStorePath(isoFilename)); err != nil {
+		return nil, err
+	}
+
+	for _, nic := range d.Networks {
+		if err := hw.addDevice(nic.Model, nic.NetDev, "mac="+nic.MACAddress); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, disk := range d.ExtraDisks {
+		if err := hw.addDevice(disk.Type, d.ResolveStorePath(disk.Filename)); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, dev := range d.Passthru {
+		if err := hw.addDevice("passthru", dev); err != nil {
+			return nil, err
+		}
+	}
+
+	// NFS shares don't need a device, the guest mounts them over the network
+	if d.ShareType != shareTypeNFS {
+		for _, share := range d.Shares {
+			options := []string{}
+			if share.ReadOnly {
+				options = append(options, "ro")
+			}
+			if err := hw.addDevice("virtio-9p", share.Tag+"="+share.HostPath, options...); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(d.ConsolePorts) > 0 {
+		options, err := d.consolePortOptions()
+		if err != nil {
+			return nil, err
+		}
+		if err := hw.addDevice("virtio-console", "", options...); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.VNC {
+		width, height, err := parseVNCResolution(d.VNCResolution)
+		if err != nil {
+			return nil, err
+		}
+		options := []string{"tcp=" + net.JoinHostPort(d.VNCBind, strconv.Itoa(d.VNCPort)), "w=" + width, "h=" + height}
+		if d.VNCPassword != "" {
+			options = append(options, "password="+d.VNCPassword)
+		}
+		if err := hw.addDevice("fbuf", "", options...); err != nil {
+			return nil, err
+		}
+		if err := hw.addDevice("xhci", "tablet"); err != nil {
+			return nil, err
+		}
+	}
+
+	return hw, nil
+}
@@ -0,0 +1,171 @@
+// Copyright 2019 Steve Wills. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bhyve
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func testDriver() *Driver {
+	d := NewDriver("default", "/store")
+	d.BhyveVMName = "docker-machine-test-default"
+	d.CPUcount = 2
+	d.MemSize = 1024
+	d.NetDev = "tap0"
+	d.MACAddress = "58:9c:fc:00:00:01"
+	return d
+}
+
+func TestBuildHardware(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(d *Driver)
+		devices []string
+	}{
+		{
+			name:  "default devices",
+			setup: func(d *Driver) {},
+			devices: []string{
+				"0:0:0,hostbridge",
+				"0:1:0,lpc",
+				"0:2:0,virtio-net,tap0,mac=58:9c:fc:00:00:01",
+				"0:3:0,virtio-blk,/store/machines/default/guest.img",
+				"0:4:0,virtio-rnd,/dev/random",
+				"0:5:0,ahci-cd,/store/machines/default/boot2docker.iso",
+			},
+		},
+		{
+			name: "extra NICs and disks",
+			setup: func(d *Driver) {
+				d.Networks = []NetworkInterface{
+					{Bridge: "bridge1", MACAddress: "58:9c:fc:00:00:02", Model: "virtio-net", NetDev: "tap1"},
+					{Bridge: "bridge2", MACAddress: "58:9c:fc:00:00:03", Model: "e1000", NetDev: "tap2"},
+				}
+				d.ExtraDisks = []ExtraDisk{
+					{Filename: "disk1.img", Type: "virtio-blk"},
+					{Filename: "disk2.img", Type: "nvme"},
+				}
+			},
+			devices: []string{
+				"0:0:0,hostbridge",
+				"0:1:0,lpc",
+				"0:2:0,virtio-net,tap0,mac=58:9c:fc:00:00:01",
+				"0:3:0,virtio-blk,/store/machines/default/guest.img",
+				"0:4:0,virtio-rnd,/dev/random",
+				"0:5:0,ahci-cd,/store/machines/default/boot2docker.iso",
+				"0:6:0,virtio-net,tap1,mac=58:9c:fc:00:00:02",
+				"0:7:0,e1000,tap2,mac=58:9c:fc:00:00:03",
+				"0:8:0,virtio-blk,/store/machines/default/disk1.img",
+				"0:9:0,nvme,/store/machines/default/disk2.img",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		d := testDriver()
+		tt.setup(d)
+
+		hw, err := d.buildHardware("/dev/nmdm0")
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+
+		devices := []string{}
+		for _, dev := range hw.Devices {
+			devices = append(devices, dev.arg())
+		}
+		if !reflect.DeepEqual(devices, tt.devices) {
+			t.Errorf("%s: got devices %q, want %q", tt.name, devices, tt.devices)
+		}
+	}
+}
+
+func TestAddDeviceSlotLimit(t *testing.T) {
+	hw := newVMHardware("vm", 1, 256)
+	for i := 0; i <= maxPCISlot; i++ {
+		if err := hw.addDevice("virtio-blk", "/dev/null"); err != nil {
+			t.Fatalf("slot %d: %s", i, err)
+		}
+	}
+
+	if err := hw.addDevice("virtio-blk", "/dev/null"); err == nil {
+		t.Errorf("expected an error past slot %d", maxPCISlot)
+	}
+}
+
+func TestHardwareArgs(t *testing.T) {
+	hw := newVMHardware("vm", 2, 512)
+	hw.Console = "/dev/nmdm0A"
+	if err := hw.addDevice("hostbridge", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := hw.addDevice("virtio-net", "tap0", "mac=58:9c:fc:00:00:01"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"-A", "-H", "-P",
+		"-s", "0:0:0,hostbridge",
+		"-s", "0:1:0,virtio-net,tap0,mac=58:9c:fc:00:00:01",
+		"-l", "com1,/dev/nmdm0A",
+		"-c", "2", "-m", "512M", "vm"}
+	if got := hw.args(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got args %q, want %q", got, want)
+	}
+}
+
+func TestHardwareConfig(t *testing.T) {
+	hw := newVMHardware("vm", 2, 512)
+	hw.Console = "/dev/nmdm0A"
+	if err := hw.addDevice("hostbridge", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := hw.addDevice("virtio-net", "tap0", "mac=58:9c:fc:00:00:01"); err != nil {
+		t.Fatal(err)
+	}
+	if err := hw.addDevice("ahci-cd", "/store/boot2docker.iso"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"acpi_tables=true",
+		"cpus=2",
+		"lpc.com1.path=/dev/nmdm0A",
+		"memory.size=512M",
+		"memory.wired=false",
+		"name=vm",
+		"pci.0.0.0.device=hostbridge",
+		"pci.0.1.0.backend=tap0",
+		"pci.0.1.0.device=virtio-net",
+		"pci.0.1.0.mac=58:9c:fc:00:00:01",
+		"pci.0.2.0.device=ahci",
+		"pci.0.2.0.port.0.path=/store/boot2docker.iso",
+		"pci.0.2.0.port.0.type=cd",
+		"rtc.use_localtime=true",
+		"x86.vmexit_on_hlt=true",
+		"x86.vmexit_on_pause=true",
+	}
+	got := strings.Split(strings.TrimSpace(hw.config()), "\n")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got config %q, want %q", got, want)
+	}
+}
+
+func TestHardwareLogArgsMasksPassword(t *testing.T) {
+	hw := newVMHardware("vm", 1, 256)
+	if err := hw.addDevice("fbuf", "", "tcp=127.0.0.1:5900", "w=1024", "h=768", "password=secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, arg := range hw.logArgs() {
+		if strings.Contains(arg, "secret") {
+			t.Errorf("password not masked in %q", arg)
+		}
+	}
+	if !strings.Contains(strings.Join(hw.args(), " "), "password=secret") {
+		t.Errorf("args lost the password")
+	}
+}
@@ -0,0 +1,360 @@
+// Copyright 2019 Steve Wills. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bhyve
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/state"
+)
+
+const (
+	vmmDir       = "/dev/vmm"
+	vmNamePrefix = "docker-machine-"
+)
+
+// InventoryEntry is a host resource used by a driver managed VM. Orphans
+// are resources whose machine no longer exists or is not running.
+type InventoryEntry struct {
+	Kind    string // machine, vm, tap, nmdm or lease
+	Name    string
+	User    string
+	Machine string
+	Orphan  bool
+	Detail  string
+
+	pidfile string // of the console logger holding an orphaned nmdm device
+}
+
+type inventory struct {
+	storePath string
+	machines  map[string]*Driver // by VM name
+	own       map[string]bool    // VM names of the machines in storePath
+	bridges   map[string]bool    // bridges of the machines in storePath
+	stores    map[string]bool
+	entries   []InventoryEntry
+}
+
+// splitVMName finds the user and machine of a docker-machine-<user>-<machine>
+// VM name. User names may contain dashes, so every split is tried against
+// the users on the host.
+func splitVMName(vmname string) (string, string, bool) {
+	rest := strings.TrimPrefix(vmname, vmNamePrefix)
+	for i := strings.Index(rest, "-"); i >= 0; i = nextDash(rest, i) {
+		if _, err := user.Lookup(rest[:i]); err == nil {
+			return rest[:i], rest[i+1:], true
+		}
+	}
+	return "", "", false
+}
+
+func nextDash(s string, i int) int {
+	j := strings.Index(s[i+1:], "-")
+	if j < 0 {
+		return -1
+	}
+	return i + 1 + j
+}
+
+func (inv *inventory) loadStore(storePath string) {
+	if inv.stores[storePath] {
+		return
+	}
+	inv.stores[storePath] = true
+
+	machines, err := ListMachines(storePath)
+	if err != nil {
+		log.Debugf("Couldn't list machines in %s: %s", storePath, err)
+		return
+	}
+	for _, m := range machines {
+		if m.BhyveVMName == "" {
+			continue
+		}
+		inv.machines[m.BhyveVMName] = m
+		if storePath == inv.storePath {
+			inv.own[m.BhyveVMName] = true
+			inv.bridges[m.Bridge] = true
+			for _, nic := range m.Networks {
+				inv.bridges[nic.Bridge] = true
+			}
+		}
+	}
+}
+
+// userStore returns the default docker-machine store of username.
+func userStore(username string) string {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(u.HomeDir, ".docker", "machine")
+}
+
+func (inv *inventory) add(entry InventoryEntry) {
+	inv.entries = append(inv.entries, entry)
+}
+
+func (inv *inventory) collectVMs() {
+	files, err := ioutil.ReadDir(vmmDir)
+	if err != nil {
+		log.Debugf("Couldn't read %s: %s", vmmDir, err)
+		return
+	}
+
+	for _, f := range files {
+		if !strings.HasPrefix(f.Name(), vmNamePrefix) {
+			continue
+		}
+		entry := InventoryEntry{Kind: "vm", Name: f.Name()}
+		username, machine, ok := splitVMName(f.Name())
+		if !ok {
+			entry.Detail = "unknown owner"
+			inv.add(entry)
+			continue
+		}
+		entry.User = username
+		entry.Machine = machine
+
+		store := userStore(username)
+		inv.loadStore(store)
+		if _, known := inv.machines[f.Name()]; !known {
+			entry.Detail = inv.unknownVMDetail(f.Name(), store, machine)
+			entry.Orphan = entry.Detail == ""
+			if entry.Orphan {
+				entry.Detail = "no machine in store"
+			}
+		}
+		inv.add(entry)
+	}
+}
+
+// unknownVMDetail explains why a VM without a machine may still be in use,
+// or returns "" if it is an orphan. The owner may use another store than
+// the default one, so a VM with a bhyve process is never an orphan.
+func (inv *inventory) unknownVMDetail(vmname string, store string, machine string) string {
+	if _, err := ioutil.ReadDir(filepath.Join(store, "machines")); err != nil && !os.IsNotExist(err) {
+		return "store not readable"
+	}
+	if fileExists(filepath.Join(store, "machines", machine, configFilename)) {
+		return "store not readable"
+	}
+	if _, err := findBhyvePID(vmname); err == nil {
+		return "bhyve running, machine not in default store"
+	}
+	return ""
+}
+
+func (inv *inventory) collectMachines() {
+	names := []string{}
+	for name := range inv.machines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		m := inv.machines[name]
+		username, _, _ := splitVMName(name)
+		s, _ := m.GetState()
+		inv.add(InventoryEntry{
+			Kind:    "machine",
+			Name:    m.ResolveStorePath(""),
+			User:    username,
+			Machine: m.MachineName,
+			Detail:  s.String(),
+		})
+	}
+}
+
+// bridgeMembers returns the member interfaces of a bridge.
+func bridgeMembers(bridge string) []string {
+	out, err := exec.Command("ifconfig", bridge).Output()
+	if err != nil {
+		return nil
+	}
+
+	members := []string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "member:" {
+			members = append(members, fields[1])
+		}
+	}
+	return members
+}
+
+// collectTaps lists the taps on the machines' bridges. A tap is only an
+// orphan if no process holds it open and it sits on a bridge of a machine
+// in our own store; other bridges may belong to other bhyve tooling.
+func (inv *inventory) collectTaps() {
+	owners := map[string]*Driver{}
+	bridges := map[string]bool{}
+	for _, m := range inv.machines {
+		bridges[m.Bridge] = true
+		for _, nic := range m.Networks {
+			bridges[nic.Bridge] = true
+		}
+		if s, _ := m.GetState(); s != state.Running && s != state.Paused {
+			continue
+		}
+		for _, tap := range m.tapDevices() {
+			owners[tap] = m
+		}
+	}
+
+	bridgeNames := []string{}
+	for bridge := range bridges {
+		bridgeNames = append(bridgeNames, bridge)
+	}
+	sort.Strings(bridgeNames)
+
+	for _, bridge := range bridgeNames {
+		for _, member := range bridgeMembers(bridge) {
+			if !strings.HasPrefix(member, "tap") {
+				continue
+			}
+			entry := InventoryEntry{Kind: "tap", Name: member, Detail: "member of " + bridge}
+			if m, ok := owners[member]; ok {
+				entry.Machine = m.MachineName
+				entry.User, _, _ = splitVMName(m.BhyveVMName)
+			} else if tapOpened(member) {
+				entry.Detail += ", in use"
+			} else if !inv.bridges[bridge] {
+				entry.Detail += ", bridge not in this store"
+			} else {
+				entry.Orphan = true
+			}
+			inv.add(entry)
+		}
+	}
+}
+
+// collectNMDMs finds console loggers that outlived their machine. Only
+// loggers of our own machines are orphans, we can't stop anyone else's.
+func (inv *inventory) collectNMDMs() {
+	for name, m := range inv.machines {
+		if m.NMDMDev == "" {
+			continue
+		}
+		username, _, _ := splitVMName(name)
+		entry := InventoryEntry{Kind: "nmdm", Name: m.NMDMDev, User: username, Machine: m.MachineName}
+		s, _ := m.GetState()
+		if s != state.Running && s != state.Paused {
+			pidfile := m.ResolveStorePath("nmdm.pid")
+			if !inv.own[name] || !pidAlive(pidfile, nmdmLoggerCommand) {
+				continue
+			}
+			entry.Orphan = true
+			entry.Detail = "console logger of stopped machine"
+			entry.pidfile = pidfile
+		}
+		inv.add(entry)
+	}
+}
+
+func (inv *inventory) collectLeases() {
+	macs := map[string]*Driver{}
+	for _, m := range inv.machines {
+		macs[strings.ToLower(m.MACAddress)] = m
+		for _, nic := range m.Networks {
+			macs[strings.ToLower(nic.MACAddress)] = m
+		}
+	}
+
+	for store := range inv.stores {
+		file, err := os.Open(filepath.Join(store, "bhyve.leases"))
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			words := strings.Fields(scanner.Text())
+			if len(words) < 3 {
+				continue
+			}
+			entry := InventoryEntry{Kind: "lease", Name: words[2], Detail: words[1]}
+			if m, ok := macs[strings.ToLower(words[1])]; ok {
+				entry.Machine = m.MachineName
+				entry.User, _, _ = splitVMName(m.BhyveVMName)
+			} else {
+				entry.Orphan = true
+			}
+			inv.add(entry)
+		}
+		file.Close()
+	}
+}
+
+// Inventory reconciles the VMs in /dev/vmm, tap devices on the machines'
+// bridges, nmdm devices and dnsmasq leases with the machines in the store
+// at storePath and in the stores of the users owning running VMs.
+func Inventory(storePath string) ([]InventoryEntry, error) {
+	inv := &inventory{
+		storePath: storePath,
+		machines:  map[string]*Driver{},
+		own:       map[string]bool{},
+		bridges:   map[string]bool{},
+		stores:    map[string]bool{},
+	}
+
+	inv.loadStore(storePath)
+	inv.collectVMs()
+	inv.collectMachines()
+	inv.collectTaps()
+	inv.collectNMDMs()
+	inv.collectLeases()
+
+	return inv.entries, nil
+}
+
+// ReapOrphans destroys orphaned VMs and tap devices and stops console
+// loggers of stopped machines. Orphaned leases expire on their own.
+func ReapOrphans(entries []InventoryEntry) error {
+	for _, entry := range entries {
+		if !entry.Orphan {
+			continue
+		}
+
+		var err error
+		switch entry.Kind {
+		case "vm":
+			log.Infof("Destroying orphaned VM %s", entry.Name)
+			err = destroyVM(entry.Name)
+		case "tap":
+			log.Infof("Destroying orphaned tap device %s", entry.Name)
+			err = destroyTap(entry.Name)
+		case "nmdm":
+			log.Infof("Stopping console logger on %s", entry.Name)
+			if err = killConsoleLogger(entry.pidfile); err == nil {
+				err = os.Remove(entry.pidfile)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e InventoryEntry) String() string {
+	owner := e.Machine
+	if e.User != "" {
+		owner = e.User + "/" + e.Machine
+	}
+	status := ""
+	if e.Orphan {
+		status = "ORPHAN"
+	}
+	return strings.Join([]string{e.Kind, e.Name, owner, status, e.Detail}, "\t")
+}
@@ -0,0 +1,137 @@
+// Copyright 2019 Steve Wills. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bhyve
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	ipDiscoveryLease = "lease"
+	ipDiscoveryARP   = "arp"
+	ipDiscoveryGuest = "guest"
+
+	// Guests report their addresses by writing a line starting with
+	// guestIPPrefix followed by one or more addresses to the serial console
+	// or to the guestIPPort virtio-console port.
+	guestIPPrefix = "docker-machine-ip:"
+	guestIPPort   = "org.docker-machine.ip"
+)
+
+var arpEntryRegexp = regexp.MustCompile(`\(([0-9.]+)\) at ([0-9a-fA-F:]+)`)
+
+// discoverIP finds the IP of the machine using its IP discovery strategy.
+func (d *Driver) discoverIP() (string, error) {
+	switch d.IPDiscovery {
+	case ipDiscoveryARP:
+		log.Debugf("getting IP from ARP table")
+		return getIPfromARP(d.MACAddress)
+	case ipDiscoveryGuest:
+		log.Debugf("getting IP reported by guest")
+		return d.getIPfromGuest()
+	default:
+		log.Debugf("getting IP from DHCP lease")
+		return getIPfromDHCPLease(filepath.Join(d.StorePath, "bhyve.leases"), d.MACAddress)
+	}
+}
+
+func getIPfromARP(macaddress string) (string, error) {
+	mac, err := net.ParseMAC(macaddress)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := exec.Command("arp", "-an").Output()
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		m := arpEntryRegexp.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		entry, err := net.ParseMAC(m[2])
+		if err != nil || entry.String() != mac.String() {
+			continue
+		}
+		log.Debugf("Found our MAC in ARP table, IP is: %s", m[1])
+		return m[1], nil
+	}
+
+	return "", errors.New("IP Not Found")
+}
+
+// parseGuestIP returns the first usable IPv4 address of a guest report line.
+func parseGuestIP(line string) string {
+	idx := strings.Index(line, guestIPPrefix)
+	if idx < 0 {
+		return ""
+	}
+
+	for _, field := range strings.Fields(line[idx+len(guestIPPrefix):]) {
+		ip := net.ParseIP(strings.Split(field, "/")[0])
+		if ip == nil || ip.To4() == nil || ip.IsLoopback() {
+			continue
+		}
+		return ip.String()
+	}
+	return ""
+}
+
+func (d *Driver) getIPfromGuest() (string, error) {
+	for _, name := range d.ConsolePorts {
+		if name != guestIPPort {
+			continue
+		}
+		port, err := d.ConsolePort(name)
+		if err != nil {
+			log.Debugf("Couldn't connect to console port %s: %s", name, err)
+			break
+		}
+		defer port.Close()
+		for {
+			line, err := port.Receive(2 * time.Second)
+			if err != nil {
+				break
+			}
+			if ip := parseGuestIP(line); ip != "" {
+				return ip, nil
+			}
+		}
+	}
+
+	// fall back to the last address reported on the serial console
+	file, err := os.Open(d.ResolveStorePath("console.log"))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	ip := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if found := parseGuestIP(stripCtlAndExtFromBytes(scanner.Text())); found != "" {
+			ip = found
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	if ip == "" {
+		return "", errors.New("IP Not Found")
+	}
+	return ip, nil
+}
@@ -0,0 +1,150 @@
+// Copyright 2019 Steve Wills. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bhyve
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	shareType9p  = "9p"
+	shareTypeNFS = "nfs"
+	// exports fragment owned by the driver, so /etc/exports stays the
+	// admin's; mountd has to be told to read it
+	exportsFile = "/etc/exports.docker-machine-bhyve"
+)
+
+func checkNFSServer() error {
+	if err := easyCmd("pgrep", "-x", "nfsd"); err != nil {
+		return errors.New("NFS shares need nfsd, set nfs_server_enable=\"YES\" in /etc/rc.conf and run service nfsd start")
+	}
+	if err := easyCmd("pgrep", "-f", "mountd .*"+exportsFile); err != nil {
+		return fmt.Errorf("NFS shares need mountd to read %s, set mountd_flags=\"-r -S /etc/exports %s\" in /etc/rc.conf "+
+			"and run service mountd restart", exportsFile, exportsFile)
+	}
+	return nil
+}
+
+// replaceExportsBlock returns exports with the block of lines belonging to
+// vmname replaced by lines, or removed if lines is empty.
+func replaceExportsBlock(exports string, vmname string, lines []string) string {
+	begin := "# BEGIN " + vmname
+	end := "# END " + vmname
+
+	kept := []string{}
+	inblock := false
+	for _, line := range strings.Split(strings.TrimRight(exports, "\n"), "\n") {
+		switch {
+		case line == begin:
+			inblock = true
+		case line == end:
+			inblock = false
+		case !inblock && line != "":
+			kept = append(kept, line)
+		}
+	}
+
+	if len(lines) > 0 {
+		kept = append(kept, begin)
+		kept = append(kept, lines...)
+		kept = append(kept, end)
+	}
+
+	if len(kept) == 0 {
+		return ""
+	}
+	return strings.Join(kept, "\n") + "\n"
+}
+
+// updateExports replaces the block of vmname in the exports fragment. The
+// fragment is locked so machines starting and stopping at the same time
+// don't lose each other's blocks.
+func updateExports(vmname string, lines []string) error {
+	if !fileExists(exportsFile) {
+		if len(lines) == 0 {
+			return nil
+		}
+		if err := easyCmd("sudo", "touch", exportsFile); err != nil {
+			return err
+		}
+	}
+
+	unlock, err := lockFile(exportsFile)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	exports, err := ioutil.ReadFile(exportsFile)
+	if err != nil {
+		return err
+	}
+
+	updated := replaceExportsBlock(string(exports), vmname, lines)
+	if updated == string(exports) {
+		return nil
+	}
+
+	log.Debugf("Updating NFS exports for %s", vmname)
+	if err := writeFileAsRoot(exportsFile, []byte(updated)); err != nil {
+		return err
+	}
+
+	return easyCmd("sudo", "service", "mountd", "onereload")
+}
+
+// exportShares exports the shares of d over NFS to the machine's IP only.
+func (d *Driver) exportShares(ip string) error {
+	lines := []string{}
+	for _, share := range d.Shares {
+		options := fmt.Sprintf("-mapall=%d:%d", os.Getuid(), os.Getgid())
+		if share.ReadOnly {
+			options = "-ro " + options
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %s", share.HostPath, options, ip))
+	}
+
+	return updateExports(d.BhyveVMName, lines)
+}
+
+func (d *Driver) unexportShares() error {
+	return updateExports(d.BhyveVMName, nil)
+}
+
+func (d *Driver) mountNFSShares() error {
+	hostip, _, err := net.ParseCIDR(d.Subnet)
+	if err != nil {
+		return err
+	}
+
+	if err := d.exportShares(d.IPAddress); err != nil {
+		return err
+	}
+
+	for _, share := range d.Shares {
+		options := "nolock,vers=3"
+		if share.ReadOnly {
+			options += ",ro"
+		}
+
+		log.Infof("Mounting %s in %s at %s over NFS...", share.HostPath, d.MachineName, share.guestPath())
+		cmd := fmt.Sprintf("sudo mkdir -p '%s' && sudo mount -t nfs -o %s %s:'%s' '%s'", share.guestPath(), options, hostip, share.HostPath, share.guestPath())
+		out, err := drivers.RunSSHCommandFromDriver(d, cmd)
+		log.Debugf("mount %s: %s", share.HostPath, out)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
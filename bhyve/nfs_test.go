@@ -0,0 +1,61 @@
+// Copyright 2019 Steve Wills. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bhyve
+
+import "testing"
+
+func TestReplaceExportsBlock(t *testing.T) {
+	tests := []struct {
+		name    string
+		exports string
+		lines   []string
+		want    string
+	}{
+		{
+			name:  "empty file",
+			lines: []string{"/src -mapall=1001:1001 192.168.8.2"},
+			want:  "# BEGIN vm\n/src -mapall=1001:1001 192.168.8.2\n# END vm\n",
+		},
+		{
+			name:    "remove only block",
+			exports: "# BEGIN vm\n/src -mapall=1001:1001 192.168.8.2\n# END vm\n",
+			want:    "",
+		},
+		{
+			name:    "replace block",
+			exports: "# BEGIN vm\n/old 192.168.8.2\n# END vm\n",
+			lines:   []string{"/new 192.168.8.3"},
+			want:    "# BEGIN vm\n/new 192.168.8.3\n# END vm\n",
+		},
+		{
+			name:    "keep other machines",
+			exports: "# BEGIN other\n/a 192.168.8.4\n# END other\n# BEGIN vm\n/old 192.168.8.2\n# END vm\n# BEGIN vm2\n/b 192.168.8.5\n# END vm2\n",
+			lines:   []string{"/new 192.168.8.3"},
+			want:    "# BEGIN other\n/a 192.168.8.4\n# END other\n# BEGIN vm2\n/b 192.168.8.5\n# END vm2\n# BEGIN vm\n/new 192.168.8.3\n# END vm\n",
+		},
+		{
+			name:    "remove block between others",
+			exports: "# BEGIN other\n/a 192.168.8.4\n# END other\n# BEGIN vm\n/old 192.168.8.2\n# END vm\n/manual -ro 10.0.0.1\n",
+			want:    "# BEGIN other\n/a 192.168.8.4\n# END other\n/manual -ro 10.0.0.1\n",
+		},
+		{
+			name:    "prefix of another machine",
+			exports: "# BEGIN vm2\n/b 192.168.8.5\n# END vm2\n",
+			want:    "# BEGIN vm2\n/b 192.168.8.5\n# END vm2\n",
+		},
+		{
+			name:    "unterminated block",
+			exports: "# BEGIN vm\n/old 192.168.8.2\n",
+			lines:   []string{"/new 192.168.8.3"},
+			want:    "# BEGIN vm\n/new 192.168.8.3\n# END vm\n",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := replaceExportsBlock(tt.exports, "vm", tt.lines); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2019 Steve Wills. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bhyve
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// parsePassthru checks a host PCI device in bus/slot/function form.
+func parsePassthru(spec string) error {
+	parts := strings.Split(spec, "/")
+	if len(parts) != 3 {
+		return fmt.Errorf("invalid passthru device %q, must be bus/slot/function", spec)
+	}
+	for _, part := range parts {
+		if _, err := strconv.Atoi(part); err != nil {
+			return fmt.Errorf("invalid passthru device %q, must be bus/slot/function", spec)
+		}
+	}
+	return nil
+}
+
+// checkPassthru verifies the host can pass the devices through, which
+// needs a working IOMMU and the devices attached to ppt(4).
+func checkPassthru(devices []string) error {
+	if len(devices) == 0 {
+		return nil
+	}
+
+	initialized, err := sysctlInt("hw.vmm.iommu.initialized")
+	if err != nil || initialized == 0 {
+		return errors.New("host has no usable IOMMU, PCI passthrough needs VT-d or AMD-Vi enabled in firmware " +
+			"and the devices listed in pptdevs in /boot/loader.conf")
+	}
+
+	out, err := exec.Command("pciconf", "-l").Output()
+	if err != nil {
+		return err
+	}
+
+	for _, dev := range devices {
+		selector := "@pci0:" + strings.Replace(dev, "/", ":", -1) + ":"
+		found := false
+		for _, line := range strings.Split(string(out), "\n") {
+			if !strings.Contains(line, selector) {
+				continue
+			}
+			found = true
+			if !strings.HasPrefix(line, "ppt") {
+				return fmt.Errorf("PCI device %s is not reserved for passthrough, add it to pptdevs in /boot/loader.conf", dev)
+			}
+		}
+		if !found {
+			return fmt.Errorf("PCI device %s not found", dev)
+		}
+	}
+
+	return nil
+}
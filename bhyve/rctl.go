@@ -0,0 +1,118 @@
+// Copyright 2019 Steve Wills. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bhyve
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// rctlResources maps the supported rctl(8) resources to the action applied
+// when the bhyve process exceeds its limit.
+var rctlResources = map[string]string{
+	"memoryuse": "deny",
+	"readbps":   "throttle",
+	"writebps":  "throttle",
+	"readiops":  "throttle",
+	"writeiops": "throttle",
+	"pcpu":      "deny",
+}
+
+var rctlAmountRegexp = regexp.MustCompile(`^[0-9]+[kKmMgGtT]?$`)
+
+func checkResourceLimits(limits map[string]string) error {
+	for resource, amount := range limits {
+		if _, ok := rctlResources[resource]; !ok {
+			return fmt.Errorf("unsupported rctl resource %s", resource)
+		}
+		if !rctlAmountRegexp.MatchString(amount) {
+			return fmt.Errorf("invalid %s limit %q", resource, amount)
+		}
+	}
+
+	if len(limits) == 0 {
+		return nil
+	}
+
+	enabled, err := sysctlInt("kern.racct.enable")
+	if err != nil || enabled == 0 {
+		return errors.New("resource limits need RACCT, set kern.racct.enable=1 in /boot/loader.conf and reboot")
+	}
+	return nil
+}
+
+// findBhyvePID returns the PID of the bhyve process running vmname.
+// bhyvePID returns the pid of the bhyve process running vmname.
+func bhyvePID(vmname string) (int, error) {
+	cmd := exec.Command("pgrep", "-f", "^bhyve: "+regexp.QuoteMeta(vmname)+"( |$)")
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err == nil {
+		words := strings.Fields(stdout.String())
+		if len(words) > 0 {
+			return strconv.Atoi(words[0])
+		}
+	}
+
+	return 0, fmt.Errorf("bhyve process for %s not found", vmname)
+}
+
+// findBhyvePID waits for the bhyve process of vmname to show up.
+func findBhyvePID(vmname string) (int, error) {
+	for tries := 0; tries < retrycount; tries++ {
+		if pid, err := bhyvePID(vmname); err == nil {
+			return pid, nil
+		}
+		time.Sleep(sleeptime * time.Millisecond)
+	}
+
+	return 0, fmt.Errorf("bhyve process for %s not found", vmname)
+}
+
+func applyResourceLimits(vmname string, limits map[string]string) error {
+	if len(limits) == 0 {
+		return nil
+	}
+
+	pid, err := findBhyvePID(vmname)
+	if err != nil {
+		return err
+	}
+
+	resources := make([]string, 0, len(limits))
+	for resource := range limits {
+		resources = append(resources, resource)
+	}
+	sort.Strings(resources)
+
+	for _, resource := range resources {
+		rule := fmt.Sprintf("process:%d:%s:%s=%s", pid, resource, rctlResources[resource], limits[resource])
+		log.Debugf("Adding rctl rule %s", rule)
+		if err := easyCmd("sudo", "rctl", "-a", rule); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func removeResourceLimits(vmname string) error {
+	pid, err := findBhyvePID(vmname)
+	if err != nil {
+		log.Debugf("No bhyve process for %s, no rctl rules to remove", vmname)
+		return nil
+	}
+
+	return easyCmd("sudo", "rctl", "-r", fmt.Sprintf("process:%d", pid))
+}
@@ -0,0 +1,170 @@
+// Copyright 2019 Steve Wills. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bhyve
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	// the kernel truncates process names to MAXCOMLEN
+	maxCommLen        = 19
+	dnsmasqCommand    = "dnsmasq"
+	nmdmLoggerCommand = "docker-machine-driver-bhyve-nmdm"
+)
+
+// pidAlive reports whether the process in pidfile is still running and is
+// command. After a reboot the pid may well belong to another process.
+func pidAlive(pidfile string, command string) bool {
+	data, err := ioutil.ReadFile(pidfile)
+	if err != nil {
+		return false
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		log.Debugf("Invalid pid in %s", pidfile)
+		return false
+	}
+
+	out, err := exec.Command("ps", "-p", strconv.Itoa(pid), "-o", "comm=").Output()
+	if err != nil {
+		return false
+	}
+
+	if len(command) > maxCommLen {
+		command = command[:maxCommLen]
+	}
+	comm := strings.TrimSpace(string(out))
+	if comm != command {
+		log.Debugf("pid %d in %s is %s, not %s", pid, pidfile, comm, command)
+		return false
+	}
+	return true
+}
+
+// removeStalePIDFile removes pidfile if the process it names is gone or
+// isn't command, and reports whether command is still running.
+func removeStalePIDFile(pidfile string, command string) (bool, error) {
+	if !fileExists(pidfile) {
+		return false, nil
+	}
+	if pidAlive(pidfile, command) {
+		return true, nil
+	}
+
+	log.Debugf("Removing stale pid file %s", pidfile)
+	if err := os.Remove(pidfile); err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	return false, nil
+}
+
+// tapOpened reports whether a process holds the tap device open.
+func tapOpened(tap string) bool {
+	out, err := exec.Command("ifconfig", tap).Output()
+	if err != nil {
+		return false
+	}
+	return strings.Contains(string(out), "Opened by PID")
+}
+
+// recoverTap destroys a tap device left over by a previous run. After a
+// reboot the name may already belong to another VM, so only taps that are
+// unused and still members of our bridge are destroyed.
+func recoverTap(tap string, bridge string) error {
+	if tap == "" {
+		return nil
+	}
+	if _, err := net.InterfaceByName(tap); err != nil {
+		log.Debugf("Tap device %s is gone", tap)
+		return nil
+	}
+	if tapOpened(tap) {
+		log.Debugf("Tap device %s is in use by another VM", tap)
+		return nil
+	}
+
+	for _, member := range bridgeMembers(bridge) {
+		if member == tap {
+			log.Infof("Destroying stale tap device %s", tap)
+			return destroyTap(tap)
+		}
+	}
+
+	log.Debugf("Tap device %s is not a member of %s, leaving it alone", tap, bridge)
+	return nil
+}
+
+// recoverStaleState repairs what a crashed bhyve or a host reboot left
+// behind before the VM is started again: the VM itself, the console logger,
+// the clock sync process, tap devices, the bridges and the DHCP server.
+func (d *Driver) recoverStaleState() error {
+	if fileExists("/dev/vmm/" + d.BhyveVMName) {
+		if _, err := findBhyvePID(d.BhyveVMName); err == nil {
+			return fmt.Errorf("%s is already running", d.MachineName)
+		}
+		log.Infof("Destroying stale VM %s", d.BhyveVMName)
+		if err := destroyVM(d.BhyveVMName); err != nil {
+			return err
+		}
+		d.Paused = false
+	}
+
+	nmdmpidfile := d.ResolveStorePath("nmdm.pid")
+	running, err := removeStalePIDFile(nmdmpidfile, nmdmLoggerCommand)
+	if err != nil {
+		return err
+	}
+	if running {
+		log.Debugf("Stopping console logger of previous run")
+		if err := killConsoleLogger(nmdmpidfile); err != nil {
+			return err
+		}
+		if err := os.Remove(nmdmpidfile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	d.NMDMDev = ""
+
+	if err := d.stopClockSync(); err != nil {
+		return err
+	}
+
+	if err := recoverTap(d.NetDev, d.Bridge); err != nil {
+		return err
+	}
+	d.NetDev = ""
+	for i := range d.Networks {
+		if err := recoverTap(d.Networks[i].NetDev, d.Networks[i].Bridge); err != nil {
+			return err
+		}
+		d.Networks[i].NetDev = ""
+	}
+
+	d.IPAddress = ""
+
+	if err := ensureIPForwardingEnabled(); err != nil {
+		return err
+	}
+	if err := setupnet(d.Bridge, d.Subnet); err != nil {
+		return err
+	}
+	for _, nic := range d.Networks {
+		if err := ensureBridge(nic.Bridge); err != nil {
+			return err
+		}
+	}
+
+	return startDHCPServer(d.StorePath, d.Bridge, d.DHCPRange)
+}
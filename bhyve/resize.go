@@ -0,0 +1,166 @@
+// Copyright 2019 Steve Wills. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bhyve
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	sectorSize       = 512
+	mbrPartitionBase = 446
+	linuxPartition   = 0x83
+	// MBR partition entries address at most 2^32-1 sectors
+	maxMBRDiskSize = int64(1<<32-1) * sectorSize
+)
+
+// ResizeDisk grows the guest disk of a stopped machine to size bytes. The
+// boot2docker data partition is grown in the partition table right away and
+// its filesystem on the next Start.
+func (d *Driver) ResizeDisk(size int64) error {
+	if err := d.checkStopped(); err != nil {
+		return err
+	}
+	if size < d.DiskSize {
+		return fmt.Errorf("refusing to shrink disk of %s from %dMB to %dMB", d.MachineName, d.DiskSize/1024/1024, size/1024/1024)
+	}
+	if size == d.DiskSize {
+		return nil
+	}
+	if size > maxMBRDiskSize {
+		return fmt.Errorf("disks larger than %dMB can't be partitioned with MBR", maxMBRDiskSize/1024/1024)
+	}
+
+	log.Infof("Resizing disk of %s to %dMB...", d.MachineName, size/1024/1024)
+	var err error
+	if d.ZVol != "" {
+		err = easyCmd("sudo", "zfs", "set", fmt.Sprintf("volsize=%d", size), d.ZVol)
+	} else {
+		err = os.Truncate(d.ResolveStorePath(diskname), size)
+	}
+	if err != nil {
+		return err
+	}
+
+	mbr, err := d.readDisk(0, sectorSize)
+	if err != nil {
+		return err
+	}
+	mbr, err = growLastPartition(mbr, size)
+	if err != nil {
+		return err
+	}
+	if mbr == nil {
+		log.Debugf("No partition to grow on %s", d.diskPath())
+		d.DiskSize = size
+		return nil
+	}
+	if err := d.writeDisk(0, mbr); err != nil {
+		return err
+	}
+	d.DiskSize = size
+	d.GrowDataPartition = true
+
+	return nil
+}
+
+// growLastPartition extends the MBR partition that ends last to the end of
+// a disk of size bytes. It returns nil if the disk has no partition table yet,
+// which is the case before boot2docker formatted it on first boot.
+func growLastPartition(mbr []byte, size int64) ([]byte, error) {
+	if len(mbr) < sectorSize || mbr[510] != 0x55 || mbr[511] != 0xAA {
+		return nil, nil
+	}
+
+	last := -1
+	var lastEnd uint32
+	for i := 0; i < 4; i++ {
+		entry := mbr[mbrPartitionBase+16*i : mbrPartitionBase+16*(i+1)]
+		if entry[4] == 0 {
+			continue
+		}
+		end := binary.LittleEndian.Uint32(entry[8:12]) + binary.LittleEndian.Uint32(entry[12:16])
+		if end > lastEnd {
+			last = i
+			lastEnd = end
+		}
+	}
+	if last < 0 {
+		return nil, nil
+	}
+
+	entry := mbr[mbrPartitionBase+16*last : mbrPartitionBase+16*(last+1)]
+	if entry[4] != linuxPartition {
+		return nil, errors.New("last partition is not a Linux partition, can't grow it")
+	}
+
+	if size > maxMBRDiskSize {
+		return nil, errors.New("disk is too large for an MBR partition table")
+	}
+	start := binary.LittleEndian.Uint32(entry[8:12])
+	sectors := uint32(size/sectorSize - int64(start))
+	if sectors <= binary.LittleEndian.Uint32(entry[12:16]) {
+		return nil, nil
+	}
+
+	grown := make([]byte, len(mbr))
+	copy(grown, mbr)
+	entry = grown[mbrPartitionBase+16*last : mbrPartitionBase+16*(last+1)]
+	binary.LittleEndian.PutUint32(entry[12:16], sectors)
+	// CHS can't address the new end, so mark it as beyond CHS range
+	entry[5], entry[6], entry[7] = 0xFE, 0xFF, 0xFF
+
+	return grown, nil
+}
+
+func (d *Driver) readDisk(offset int64, length int) ([]byte, error) {
+	if d.ZVol != "" {
+		return readFromDevice(d.diskPath(), offset, length)
+	}
+
+	f, err := os.Open(d.diskPath())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, length)
+	if _, err := f.ReadAt(buf, offset); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (d *Driver) writeDisk(offset int64, data []byte) error {
+	if d.ZVol != "" {
+		return writeToDevice(d.diskPath(), offset, data)
+	}
+
+	f, err := os.OpenFile(d.diskPath(), os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.WriteAt(data, offset); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// growDataFilesystem grows the mounted boot2docker data filesystem to fill
+// its partition.
+func (d *Driver) growDataFilesystem() error {
+	log.Infof("Growing data filesystem of %s...", d.MachineName)
+	out, err := drivers.RunSSHCommandFromDriver(d, "sudo resize2fs $(blkid -o device -l -t LABEL=boot2docker-data)")
+	log.Debugf("resize2fs: %s", out)
+	return err
+}
@@ -0,0 +1,116 @@
+// Copyright 2019 Steve Wills. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bhyve
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+type testPartition struct {
+	kind    byte
+	start   uint32
+	sectors uint32
+}
+
+func testMBR(signed bool, partitions ...testPartition) []byte {
+	mbr := make([]byte, sectorSize)
+	for i, p := range partitions {
+		entry := mbr[mbrPartitionBase+16*i : mbrPartitionBase+16*(i+1)]
+		entry[4] = p.kind
+		entry[5], entry[6], entry[7] = 0x01, 0x02, 0x03
+		binary.LittleEndian.PutUint32(entry[8:12], p.start)
+		binary.LittleEndian.PutUint32(entry[12:16], p.sectors)
+	}
+	if signed {
+		mbr[510], mbr[511] = 0x55, 0xAA
+	}
+	return mbr
+}
+
+func TestGrowLastPartition(t *testing.T) {
+	const mb = 1024 * 1024
+
+	tests := []struct {
+		name    string
+		mbr     []byte
+		size    int64
+		err     bool
+		grown   bool
+		index   int
+		sectors uint32
+	}{
+		{
+			name: "no signature",
+			mbr:  testMBR(false, testPartition{linuxPartition, 2048, 2048}),
+			size: 100 * mb,
+		},
+		{
+			name: "no partitions",
+			mbr:  testMBR(true),
+			size: 100 * mb,
+		},
+		{
+			name: "last partition not Linux",
+			mbr:  testMBR(true, testPartition{linuxPartition, 2048, 2048}, testPartition{0x82, 4096, 2048}),
+			size: 100 * mb,
+			err:  true,
+		},
+		{
+			name: "already full size",
+			mbr:  testMBR(true, testPartition{linuxPartition, 2048, 100*mb/sectorSize - 2048}),
+			size: 100 * mb,
+		},
+		{
+			name:    "grow",
+			mbr:     testMBR(true, testPartition{0x82, 2048, 2048}, testPartition{linuxPartition, 4096, 2048}),
+			size:    100 * mb,
+			grown:   true,
+			index:   1,
+			sectors: 100*mb/sectorSize - 4096,
+		},
+		{
+			name: "beyond MBR limit",
+			mbr:  testMBR(true, testPartition{linuxPartition, 2048, 2048}),
+			size: maxMBRDiskSize + sectorSize,
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		grown, err := growLastPartition(tt.mbr, tt.size)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err)
+			continue
+		}
+		if !tt.grown {
+			if grown != nil {
+				t.Errorf("%s: expected no change", tt.name)
+			}
+			continue
+		}
+		if grown == nil {
+			t.Errorf("%s: expected a grown partition", tt.name)
+			continue
+		}
+
+		entry := grown[mbrPartitionBase+16*tt.index : mbrPartitionBase+16*(tt.index+1)]
+		if sectors := binary.LittleEndian.Uint32(entry[12:16]); sectors != tt.sectors {
+			t.Errorf("%s: got %d sectors, want %d", tt.name, sectors, tt.sectors)
+		}
+		if entry[5] != 0xFE || entry[6] != 0xFF || entry[7] != 0xFF {
+			t.Errorf("%s: got CHS end % x, want fe ff ff", tt.name, entry[5:8])
+		}
+		if tt.mbr[mbrPartitionBase+16*tt.index+5] != 0x01 {
+			t.Errorf("%s: input MBR was modified", tt.name)
+		}
+	}
+}
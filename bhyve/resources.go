@@ -0,0 +1,243 @@
+// Copyright 2019 Steve Wills. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bhyve
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/state"
+)
+
+const (
+	overcommitRefuse = "refuse"
+	overcommitWarn   = "warn"
+	overcommitAllow  = "allow"
+)
+
+// validateTopology checks a CPU, memory (in MB) and topology configuration
+// for consistency. Zero sockets, cores and threads means no topology.
+func validateTopology(cpus int, memsize int64, sockets int, cores int, threads int) error {
+	if cpus < 1 {
+		return fmt.Errorf("invalid CPU count %d", cpus)
+	}
+	if memsize < 1 {
+		return fmt.Errorf("invalid memory size %dMB", memsize)
+	}
+
+	if sockets != 0 || cores != 0 || threads != 0 {
+		if sockets < 1 || cores < 1 || threads < 1 {
+			return fmt.Errorf("CPU topology needs sockets, cores and threads")
+		}
+		if sockets*cores*threads != cpus {
+			return fmt.Errorf("CPU topology %d sockets * %d cores * %d threads doesn't match %d CPUs", sockets, cores, threads, cpus)
+		}
+	}
+
+	return nil
+}
+
+// validateResources checks a CPU, memory (in MB) and topology configuration
+// against the host.
+func validateResources(cpus int, memsize int64, sockets int, cores int, threads int) error {
+	if err := validateTopology(cpus, memsize, sockets, cores, threads); err != nil {
+		return err
+	}
+
+	ncpu, err := sysctlInt("hw.ncpu")
+	if err != nil {
+		return err
+	}
+	if int64(cpus) > ncpu {
+		return fmt.Errorf("%d CPUs requested but host only has %d", cpus, ncpu)
+	}
+
+	availmem, err := hostAvailableMemory()
+	if err != nil {
+		return err
+	}
+	if memsize*1024*1024 > availmem {
+		return fmt.Errorf("%dMB memory requested but host only has %dMB available", memsize, availmem/1024/1024)
+	}
+
+	return nil
+}
+
+// hostAvailableMemory returns the bytes of memory the host can hand out:
+// free and inactive pages plus what the ZFS ARC can give back. Wired memory
+// isn't available, but the ARC is wired and shrinks under pressure.
+func hostAvailableMemory() (int64, error) {
+	pagesize, err := sysctlInt("hw.pagesize")
+	if err != nil {
+		return 0, err
+	}
+	freecount, err := sysctlInt("vm.stats.vm.v_free_count")
+	if err != nil {
+		return 0, err
+	}
+	inactivecount, err := sysctlInt("vm.stats.vm.v_inactive_count")
+	if err != nil {
+		return 0, err
+	}
+	availmem := (freecount + inactivecount) * pagesize
+
+	// without ZFS loaded these don't exist
+	arcsize, err := sysctlInt("kstat.zfs.misc.arcstats.size")
+	if err == nil {
+		arcmin, err := sysctlInt("kstat.zfs.misc.arcstats.c_min")
+		if err == nil && arcsize > arcmin {
+			availmem += arcsize - arcmin
+		}
+	}
+
+	return availmem, nil
+}
+
+// SetResources changes the CPUs, memory (in MB) and CPU topology of a
+// stopped machine. They take effect on the next Start.
+func (d *Driver) SetResources(cpus int, memsize int64, sockets int, cores int, threads int) error {
+	if err := d.checkStopped(); err != nil {
+		return err
+	}
+	if err := validateResources(cpus, memsize, sockets, cores, threads); err != nil {
+		return err
+	}
+	if d.CPUPin != "" && d.CPUPin != cpuPinAuto {
+		if _, err := parseCPUPins(d.CPUPin, cpus); err != nil {
+			return fmt.Errorf("CPU pinning %s doesn't fit %d CPUs: %s", d.CPUPin, cpus, err)
+		}
+	}
+
+	log.Infof("Setting %s to %d CPUs and %dMB memory", d.MachineName, cpus, memsize)
+	d.CPUcount = cpus
+	d.MemSize = memsize
+	d.CPUSockets = sockets
+	d.CPUCores = cores
+	d.CPUThreads = threads
+
+	return nil
+}
+
+// checkHostResources checks that the host has enough free memory and CPUs
+// to start d next to the other running machines in the store, and refuses
+// or warns according to d.Overcommit.
+func (d *Driver) checkHostResources() error {
+	if d.Overcommit == overcommitAllow {
+		return nil
+	}
+
+	hostmem, err := hostAvailableMemory()
+	if err != nil {
+		return err
+	}
+	ncpu, err := sysctlInt("hw.ncpu")
+	if err != nil {
+		return err
+	}
+
+	machines, err := ListMachines(d.StorePath)
+	if err != nil {
+		return err
+	}
+
+	usedmem := int64(0)
+	usedcpus := int64(0)
+	consumers := []string{}
+	for _, m := range machines {
+		if m.BhyveVMName == d.BhyveVMName {
+			continue
+		}
+		if s, _ := m.GetState(); s != state.Running {
+			continue
+		}
+		// resident guest memory is already out of the free and inactive
+		// pages, only count what the guest may still fault in
+		if resident, err := vmResidentMemory(m.BhyveVMName); err == nil && resident < m.MemSize*1024*1024 {
+			usedmem += m.MemSize*1024*1024 - resident
+		} else if err != nil {
+			usedmem += m.MemSize * 1024 * 1024
+		}
+		usedcpus += int64(m.CPUcount)
+		consumers = append(consumers, fmt.Sprintf("%s (%d CPUs, %dMB)", m.MachineName, m.CPUcount, m.MemSize))
+	}
+
+	availmem := hostmem - usedmem
+	problems := []string{}
+	if d.MemSize*1024*1024 > availmem {
+		problems = append(problems, fmt.Sprintf("%dMB memory requested but only %dMB available", d.MemSize, availmem/1024/1024))
+	}
+	if int64(d.CPUcount)+usedcpus > ncpu {
+		problems = append(problems, fmt.Sprintf("%d CPUs requested but %d of %d host CPUs are in use", d.CPUcount, usedcpus, ncpu))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+
+	msg := fmt.Sprintf("host is overcommitted for %s: %s", d.MachineName, strings.Join(problems, ", "))
+	if len(consumers) > 0 {
+		msg += "; running machines: " + strings.Join(consumers, ", ")
+	}
+
+	if d.Overcommit == overcommitRefuse {
+		return errors.New(msg)
+	}
+	log.Warn(msg)
+	return nil
+}
+
+// checkVMMCapabilities checks the VM options of d against what vmm(4) on
+// this host supports.
+func (d *Driver) checkVMMCapabilities() error {
+	// Intel hosts report exiting capabilities, AMD hosts always have them
+	if !d.NoHLTExit {
+		if supported, err := sysctlInt("hw.vmm.vmx.cap.halt_exit"); err == nil && supported == 0 {
+			return errors.New("host doesn't support exiting on HLT, use --bhyve-no-hlt-exit")
+		}
+	}
+	if !d.NoPauseExit {
+		if supported, err := sysctlInt("hw.vmm.vmx.cap.pause_exit"); err == nil && supported == 0 {
+			return errors.New("host doesn't support exiting on PAUSE, use --bhyve-no-pause-exit")
+		}
+	}
+
+	if maxcpu, err := sysctlInt("hw.vmm.maxcpu"); err == nil && int64(d.CPUcount) > maxcpu {
+		return fmt.Errorf("%d CPUs requested but vmm supports at most %d per VM", d.CPUcount, maxcpu)
+	}
+
+	if d.WireMemory {
+		physmem, err := sysctlInt("hw.physmem")
+		if err != nil {
+			return err
+		}
+		pagesize, err := sysctlInt("hw.pagesize")
+		if err != nil {
+			return err
+		}
+		wirecount, err := sysctlInt("vm.stats.vm.v_wire_count")
+		if err != nil {
+			return err
+		}
+		if d.MemSize*1024*1024 > physmem-wirecount*pagesize {
+			return fmt.Errorf("can't wire %dMB memory, only %dMB unwired", d.MemSize, (physmem-wirecount*pagesize)/1024/1024)
+		}
+	}
+
+	return nil
+}
+
+// vmResidentMemory returns the bytes of guest memory backed by host pages.
+func vmResidentMemory(vmname string) (int64, error) {
+	counters, err := vcpuStats(vmname, 0)
+	if err != nil {
+		return 0, err
+	}
+	resident, ok := counters["Resident memory"]
+	if !ok {
+		return 0, errors.New("no resident memory statistics for " + vmname)
+	}
+	return int64(resident), nil
+}
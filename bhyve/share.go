@@ -0,0 +1,98 @@
+// Copyright 2019 Steve Wills. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bhyve
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/log"
+)
+
+// Share is a host directory shared into the guest. Unless GuestPath is set
+// it is mounted at the same path in the guest, so bind mounts of host paths
+// work unchanged.
+type Share struct {
+	HostPath  string
+	GuestPath string
+	Tag       string
+	ReadOnly  bool
+}
+
+// parseShare parses a hostpath:tag[:guestpath][:ro] share.
+func parseShare(spec string) (Share, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 4 {
+		return Share{}, fmt.Errorf("invalid share %q, must be hostpath:tag[:guestpath][:ro]", spec)
+	}
+
+	share := Share{
+		HostPath: filepath.Clean(parts[0]),
+		Tag:      parts[1],
+	}
+	options := parts[2:]
+	if len(options) > 0 && options[0] != "ro" {
+		share.GuestPath = filepath.Clean(options[0])
+		if !filepath.IsAbs(share.GuestPath) {
+			return share, fmt.Errorf("guest path %s of share %q must be absolute", options[0], spec)
+		}
+		options = options[1:]
+	}
+	if len(options) > 0 {
+		if len(options) > 1 || options[0] != "ro" {
+			return share, fmt.Errorf("invalid share option %q in %q", strings.Join(options, ":"), spec)
+		}
+		share.ReadOnly = true
+	}
+
+	if !filepath.IsAbs(share.HostPath) {
+		return share, fmt.Errorf("share path %s must be absolute", share.HostPath)
+	}
+	if info, err := os.Stat(share.HostPath); err != nil || !info.IsDir() {
+		return share, fmt.Errorf("share path %s is not a directory", share.HostPath)
+	}
+	if share.Tag == "" || strings.ContainsAny(share.Tag, ",= ") {
+		return share, fmt.Errorf("invalid share tag %q", share.Tag)
+	}
+
+	return share, nil
+}
+
+// guestPath returns where the share is mounted in the guest.
+func (s Share) guestPath() string {
+	if s.GuestPath != "" {
+		return s.GuestPath
+	}
+	return s.HostPath
+}
+
+func (d *Driver) mountShares() error {
+	if len(d.Shares) == 0 {
+		return nil
+	}
+	if d.ShareType == shareTypeNFS {
+		return d.mountNFSShares()
+	}
+
+	for _, share := range d.Shares {
+		options := "trans=virtio,version=9p2000.L"
+		if share.ReadOnly {
+			options += ",ro"
+		}
+
+		log.Infof("Mounting %s in %s at %s...", share.HostPath, d.MachineName, share.guestPath())
+		cmd := fmt.Sprintf("sudo mkdir -p '%s' && sudo mount -t 9p -o %s %s '%s'", share.guestPath(), options, share.Tag, share.guestPath())
+		out, err := drivers.RunSSHCommandFromDriver(d, cmd)
+		log.Debugf("mount %s: %s", share.Tag, out)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2019 Steve Wills. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bhyve
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestParseShare(t *testing.T) {
+	dir, err := ioutil.TempDir("", "share")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		spec  string
+		err   bool
+		share Share
+	}{
+		{spec: dir + ":src", share: Share{HostPath: dir, Tag: "src"}},
+		{spec: dir + ":src:ro", share: Share{HostPath: dir, Tag: "src", ReadOnly: true}},
+		{spec: dir + ":src:/data", share: Share{HostPath: dir, GuestPath: "/data", Tag: "src"}},
+		{spec: dir + ":src:/data:ro", share: Share{HostPath: dir, GuestPath: "/data", Tag: "src", ReadOnly: true}},
+		{spec: dir + ":src:data", err: true},
+		{spec: dir + ":src:/data:rw", err: true},
+		{spec: dir + ":src:ro:ro", err: true},
+		{spec: dir, err: true},
+		{spec: dir + ":a,b", err: true},
+	}
+
+	for _, tt := range tests {
+		share, err := parseShare(tt.spec)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", tt.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tt.spec, err)
+			continue
+		}
+		if share != tt.share {
+			t.Errorf("%s: got %+v, want %+v", tt.spec, share, tt.share)
+		}
+	}
+}
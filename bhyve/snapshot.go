@@ -0,0 +1,219 @@
+// Copyright 2019 Steve Wills. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bhyve
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/state"
+	"gitlab.mouf.net/swills/docker-machine-driver-bhyve/b2d"
+)
+
+const snapshotsFilename = "snapshots.json"
+
+var snapshotNameRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+type Snapshot struct {
+	Name       string
+	Time       time.Time
+	DiskSize   int64
+	ISOVersion string
+}
+
+func (d *Driver) Snapshots() ([]Snapshot, error) {
+	snapshots := []Snapshot{}
+
+	data, err := ioutil.ReadFile(d.ResolveStorePath(snapshotsFilename))
+	if os.IsNotExist(err) {
+		return snapshots, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &snapshots); err != nil {
+		return nil, err
+	}
+
+	return snapshots, nil
+}
+
+func (d *Driver) writeSnapshots(snapshots []Snapshot) error {
+	data, err := json.MarshalIndent(snapshots, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(d.ResolveStorePath(snapshotsFilename), data, 0644)
+}
+
+func (d *Driver) findSnapshot(name string) (Snapshot, int, error) {
+	snapshots, err := d.Snapshots()
+	if err != nil {
+		return Snapshot{}, -1, err
+	}
+
+	for i, snap := range snapshots {
+		if snap.Name == name {
+			return snap, i, nil
+		}
+	}
+
+	return Snapshot{}, -1, fmt.Errorf("snapshot %s of %s not found", name, d.MachineName)
+}
+
+func (d *Driver) checkStopped() error {
+	s, err := d.GetState()
+	if err != nil {
+		return err
+	}
+	if s != state.Stopped {
+		return fmt.Errorf("machine %s must be stopped", d.MachineName)
+	}
+	return nil
+}
+
+func (d *Driver) snapshotDir(name string) string {
+	return d.ResolveStorePath(filepath.Join("snapshots", name))
+}
+
+// snapshotFiles returns the file backed disks of the machine, mapped to
+// their copy in the snapshot named name.
+func (d *Driver) snapshotFiles(name string) map[string]string {
+	files := map[string]string{}
+	if d.ZVol == "" {
+		files[d.ResolveStorePath(diskname)] = filepath.Join(d.snapshotDir(name), diskname)
+	}
+	for _, disk := range d.ExtraDisks {
+		files[d.ResolveStorePath(disk.Filename)] = filepath.Join(d.snapshotDir(name), disk.Filename)
+	}
+	return files
+}
+
+func (d *Driver) CreateSnapshot(name string) error {
+	if !snapshotNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid snapshot name %q", name)
+	}
+	if err := d.checkStopped(); err != nil {
+		return err
+	}
+
+	snapshots, err := d.Snapshots()
+	if err != nil {
+		return err
+	}
+	for _, snap := range snapshots {
+		if snap.Name == name {
+			return fmt.Errorf("snapshot %s of %s already exists", name, d.MachineName)
+		}
+	}
+
+	isoVersion, err := b2d.ISOVersion(d.ResolveStorePath(isoFilename))
+	if err != nil {
+		log.Debugf("Couldn't get ISO version: %s", err)
+	}
+
+	log.Infof("Creating snapshot %s of %s...", name, d.MachineName)
+	if err := os.MkdirAll(d.snapshotDir(name), 0700); err != nil {
+		return err
+	}
+	for src, dst := range d.snapshotFiles(name) {
+		if err := cloneFile(src, dst); err != nil {
+			return err
+		}
+	}
+	if d.ZVol != "" {
+		if err := easyCmd("sudo", "zfs", "snapshot", d.ZVol+"@"+name); err != nil {
+			return err
+		}
+	}
+
+	snapshots = append(snapshots, Snapshot{
+		Name:       name,
+		Time:       time.Now(),
+		DiskSize:   d.DiskSize,
+		ISOVersion: isoVersion,
+	})
+
+	return d.writeSnapshots(snapshots)
+}
+
+func (d *Driver) RestoreSnapshot(name string) error {
+	if err := d.checkStopped(); err != nil {
+		return err
+	}
+
+	snap, i, err := d.findSnapshot(name)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Restoring snapshot %s of %s...", name, d.MachineName)
+	for dst, src := range d.snapshotFiles(name) {
+		if err := cloneFile(src, dst); err != nil {
+			return err
+		}
+	}
+	if d.ZVol != "" {
+		if err := easyCmd("sudo", "zfs", "rollback", "-r", d.ZVol+"@"+name); err != nil {
+			return err
+		}
+		// rollback -r destroyed the zvol snapshots newer than this one
+		if err := d.dropSnapshotsAfter(i); err != nil {
+			return err
+		}
+	}
+	d.DiskSize = snap.DiskSize
+
+	return nil
+}
+
+func (d *Driver) DeleteSnapshot(name string) error {
+	_, i, err := d.findSnapshot(name)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Deleting snapshot %s of %s...", name, d.MachineName)
+	if d.ZVol != "" {
+		if err := easyCmd("sudo", "zfs", "destroy", d.ZVol+"@"+name); err != nil {
+			return err
+		}
+	}
+	if err := os.RemoveAll(d.snapshotDir(name)); err != nil {
+		return err
+	}
+
+	snapshots, err := d.Snapshots()
+	if err != nil {
+		return err
+	}
+
+	return d.writeSnapshots(append(snapshots[:i], snapshots[i+1:]...))
+}
+
+// dropSnapshotsAfter removes the snapshots newer than the one at index i.
+func (d *Driver) dropSnapshotsAfter(i int) error {
+	snapshots, err := d.Snapshots()
+	if err != nil {
+		return err
+	}
+
+	for _, snap := range snapshots[i+1:] {
+		log.Infof("Removing snapshot %s, destroyed by the rollback", snap.Name)
+		if err := os.RemoveAll(d.snapshotDir(snap.Name)); err != nil {
+			return err
+		}
+	}
+
+	return d.writeSnapshots(snapshots[:i+1])
+}
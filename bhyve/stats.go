@@ -0,0 +1,273 @@
+// Copyright 2019 Steve Wills. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bhyve
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/docker/machine/libmachine/drivers"
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/state"
+)
+
+type VCPUStats struct {
+	VCPU     int
+	Exits    uint64
+	Counters map[string]uint64
+}
+
+// InterfaceStats are the counters of a tap device as seen by the host, so
+// In is traffic sent by the guest and Out traffic received by it.
+type InterfaceStats struct {
+	Name       string
+	InPackets  uint64
+	InBytes    uint64
+	OutPackets uint64
+	OutBytes   uint64
+}
+
+// DiskStats are the I/O counters of a virtual disk as the guest sees them,
+// Device is the guest's name for the disk and Path its host backing.
+type DiskStats struct {
+	Device     string
+	Path       string
+	Reads      uint64
+	ReadBytes  uint64
+	Writes     uint64
+	WriteBytes uint64
+}
+
+type Stats struct {
+	Machine        string
+	VM             string
+	Uptime         int64 // seconds
+	ResidentMemory uint64
+	WiredMemory    uint64
+	VCPUs          []VCPUStats
+	Interfaces     []InterfaceStats
+	Disks          []DiskStats
+}
+
+// parseVMMStats parses the output of bhyvectl --get-stats for one vCPU.
+func parseVMMStats(out string) map[string]uint64 {
+	counters := map[string]uint64{}
+	for _, line := range strings.Split(out, "\n") {
+		idx := strings.LastIndex(line, "\t")
+		if idx < 0 {
+			continue
+		}
+		value, err := strconv.ParseUint(strings.TrimSpace(line[idx+1:]), 10, 64)
+		if err != nil {
+			continue
+		}
+		counters[strings.TrimSpace(line[:idx])] = value
+	}
+	return counters
+}
+
+func vcpuStats(vmname string, vcpu int) (map[string]uint64, error) {
+	cmd := exec.Command("sudo", "bhyvectl", "--vm="+vmname, "--cpu="+strconv.Itoa(vcpu), "--get-stats")
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+	return parseVMMStats(stdout.String()), nil
+}
+
+// parseNetstat parses the link level line of netstat -ibn -I output.
+func parseNetstat(name string, out string) (InterfaceStats, error) {
+	stats := InterfaceStats{Name: name}
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) < 2 {
+		return stats, fmt.Errorf("no statistics for %s", name)
+	}
+	header := strings.Fields(lines[0])
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) != len(header) || !strings.HasPrefix(fields[2], "<Link") {
+			continue
+		}
+		for i, column := range header {
+			value, err := strconv.ParseUint(fields[i], 10, 64)
+			if err != nil {
+				continue
+			}
+			switch column {
+			case "Ipkts":
+				stats.InPackets = value
+			case "Ibytes":
+				stats.InBytes = value
+			case "Opkts":
+				stats.OutPackets = value
+			case "Obytes":
+				stats.OutBytes = value
+			}
+		}
+		return stats, nil
+	}
+
+	return stats, fmt.Errorf("no link statistics for %s", name)
+}
+
+func interfaceStats(name string) (InterfaceStats, error) {
+	out, err := exec.Command("netstat", "-ibn", "-I", name).Output()
+	if err != nil {
+		return InterfaceStats{Name: name}, err
+	}
+	return parseNetstat(name, string(out))
+}
+
+// processUptime returns the elapsed time of a process in seconds.
+func processUptime(pid int) (int64, error) {
+	out, err := exec.Command("ps", "-o", "etimes=", "-p", strconv.Itoa(pid)).Output()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+}
+
+// guestDisks returns the guest device names of the machine's disks with
+// their host backing, in the PCI slot order the guest enumerates them in.
+func (d *Driver) guestDisks() [][2]string {
+	disks := [][2]string{{"vda", d.diskPath()}}
+	virtio, nvme := 1, 0
+	for _, disk := range d.ExtraDisks {
+		if disk.Type == "nvme" {
+			disks = append(disks, [2]string{fmt.Sprintf("nvme%dn1", nvme), d.ResolveStorePath(disk.Filename)})
+			nvme++
+		} else {
+			disks = append(disks, [2]string{"vd" + string(rune('a'+virtio)), d.ResolveStorePath(disk.Filename)})
+			virtio++
+		}
+	}
+	return disks
+}
+
+// parseDiskstats parses the guest's /proc/diskstats into counters by device.
+func parseDiskstats(out string) map[string]DiskStats {
+	disks := map[string]DiskStats{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 10 {
+			continue
+		}
+		values := make([]uint64, 10)
+		valid := true
+		for i := 3; i < 10; i++ {
+			v, err := strconv.ParseUint(fields[i], 10, 64)
+			if err != nil {
+				valid = false
+				break
+			}
+			values[i] = v
+		}
+		if !valid {
+			continue
+		}
+		// sectors in /proc/diskstats are always 512 bytes
+		disks[fields[2]] = DiskStats{
+			Device:     fields[2],
+			Reads:      values[3],
+			ReadBytes:  values[5] * 512,
+			Writes:     values[7],
+			WriteBytes: values[9] * 512,
+		}
+	}
+	return disks
+}
+
+// diskStats reads the virtio-blk and NVMe counters from inside the guest,
+// the host can't tell guest disk I/O apart from bhyve's own.
+func (d *Driver) diskStats() ([]DiskStats, error) {
+	out, err := drivers.RunSSHCommandFromDriver(d, "cat /proc/diskstats")
+	if err != nil {
+		return nil, err
+	}
+
+	counters := parseDiskstats(out)
+	disks := []DiskStats{}
+	for _, disk := range d.guestDisks() {
+		stats, ok := counters[disk[0]]
+		if !ok {
+			continue
+		}
+		stats.Path = disk[1]
+		disks = append(disks, stats)
+	}
+	return disks, nil
+}
+
+// Stats gathers statistics of the running machine from vmm(4), the bhyve
+// process, its tap devices and the guest's disks.
+func (d *Driver) Stats() (*Stats, error) {
+	if s, err := d.GetState(); err != nil || s != state.Running {
+		return nil, fmt.Errorf("machine %s is not running", d.MachineName)
+	}
+
+	stats := &Stats{
+		Machine: d.MachineName,
+		VM:      d.BhyveVMName,
+	}
+
+	for vcpu := 0; vcpu < d.CPUcount; vcpu++ {
+		counters, err := vcpuStats(d.BhyveVMName, vcpu)
+		if err != nil {
+			return nil, err
+		}
+		stats.VCPUs = append(stats.VCPUs, VCPUStats{
+			VCPU:     vcpu,
+			Exits:    counters["total number of vm exits"],
+			Counters: counters,
+		})
+		if vcpu == 0 {
+			stats.ResidentMemory = counters["Resident memory"]
+			stats.WiredMemory = counters["Wired memory"]
+		}
+	}
+
+	pid, err := findBhyvePID(d.BhyveVMName)
+	if err != nil {
+		return nil, err
+	}
+	stats.Uptime, err = processUptime(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tap := range d.tapDevices() {
+		ifstats, err := interfaceStats(tap)
+		if err != nil {
+			return nil, err
+		}
+		stats.Interfaces = append(stats.Interfaces, ifstats)
+	}
+
+	// without SSH the rest of the statistics are still useful
+	stats.Disks, err = d.diskStats()
+	if err != nil {
+		log.Debugf("Couldn't get disk statistics of %s: %s", d.MachineName, err)
+	}
+
+	return stats, nil
+}
+
+func (d *Driver) tapDevices() []string {
+	taps := []string{}
+	if d.NetDev != "" {
+		taps = append(taps, d.NetDev)
+	}
+	for _, nic := range d.Networks {
+		if nic.NetDev != "" {
+			taps = append(taps, nic.NetDev)
+		}
+	}
+	return taps
+}
@@ -0,0 +1,52 @@
+// Copyright 2019 Steve Wills. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bhyve
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDiskstats(t *testing.T) {
+	out := `   1       0 ram0 0 0 0 0 0 0 0 0 0 0 0
+ 253       0 vda 1200 30 48000 900 800 12 16000 1500 0 2000 2400
+ 253       1 vda1 1100 30 46000 850 800 12 16000 1500 0 1900 2350
+ 259       0 nvme0n1 10 0 80 1 20 0 160 3 0 4 4
+   8       0 sda garbage
+`
+	disks := parseDiskstats(out)
+
+	want := map[string]DiskStats{
+		"vda":     {Device: "vda", Reads: 1200, ReadBytes: 48000 * 512, Writes: 800, WriteBytes: 16000 * 512},
+		"nvme0n1": {Device: "nvme0n1", Reads: 10, ReadBytes: 80 * 512, Writes: 20, WriteBytes: 160 * 512},
+	}
+	for name, stats := range want {
+		if !reflect.DeepEqual(disks[name], stats) {
+			t.Errorf("%s: got %+v, want %+v", name, disks[name], stats)
+		}
+	}
+	if _, ok := disks["sda"]; ok {
+		t.Errorf("garbage line parsed")
+	}
+}
+
+func TestGuestDisks(t *testing.T) {
+	d := testDriver()
+	d.ExtraDisks = []ExtraDisk{
+		{Filename: "disk1.img", Type: "virtio-blk"},
+		{Filename: "disk2.img", Type: "nvme"},
+		{Filename: "disk3.img", Type: "virtio-blk"},
+	}
+
+	want := [][2]string{
+		{"vda", "/store/machines/default/guest.img"},
+		{"vdb", "/store/machines/default/disk1.img"},
+		{"nvme0n1", "/store/machines/default/disk2.img"},
+		{"vdc", "/store/machines/default/disk3.img"},
+	}
+	if got := d.guestDisks(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
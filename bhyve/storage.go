@@ -0,0 +1,135 @@
+// Copyright 2019 Steve Wills. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bhyve
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+// DiskUsage returns the bytes the guest disk actually uses on the host.
+func (d *Driver) DiskUsage() (int64, error) {
+	if d.ZVol != "" {
+		out, err := exec.Command("zfs", "get", "-Hp", "-o", "value", "used", d.ZVol).Output()
+		if err != nil {
+			return 0, err
+		}
+		return strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	}
+
+	info, err := os.Stat(d.diskPath())
+	if err != nil {
+		return 0, err
+	}
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int64(st.Blocks) * 512, nil
+	}
+	return info.Size(), nil
+}
+
+func (d *Driver) diskPath() string {
+	if d.ZVol != "" {
+		return zvolDevice(d.ZVol)
+	}
+	return d.ResolveStorePath(diskname)
+}
+
+// zfsDataset returns the dataset part of a "zfs:<pool/dataset>" storage spec.
+func zfsDataset(storage string) (string, bool) {
+	if !strings.HasPrefix(storage, "zfs:") {
+		return "", false
+	}
+	dataset := strings.TrimPrefix(storage, "zfs:")
+	if dataset == "" {
+		return "", false
+	}
+	return dataset, true
+}
+
+func zvolDevice(zvol string) string {
+	return "/dev/zvol/" + zvol
+}
+
+func checkZFSDataset(dataset string) error {
+	if err := checkRequiredCommand("/sbin/zfs"); err != nil {
+		return fmt.Errorf("/sbin/zfs not found")
+	}
+
+	if err := easyCmd("zfs", "list", "-H", "-o", "name", dataset); err != nil {
+		return fmt.Errorf("ZFS dataset %s does not exist", dataset)
+	}
+
+	return nil
+}
+
+func generateZVolDiskImage(sshkeypath string, zvol string, size int64) error {
+	if err := easyCmd("zfs", "list", "-H", "-o", "name", zvol); err == nil {
+		log.Debugf("zvol %s already exists", zvol)
+		return nil
+	}
+
+	err := easyCmd("sudo", "zfs", "create", "-s", "-V", strconv.FormatInt(size, 10), "-o", "volmode=dev", zvol)
+	if err != nil {
+		return err
+	}
+
+	device := zvolDevice(zvol)
+	for tries := 0; !fileExists(device); tries++ {
+		if tries > retrycount {
+			return fmt.Errorf("zvol device %s did not appear", device)
+		}
+		time.Sleep(sleeptime * time.Millisecond)
+	}
+
+	tarBuf, err := generateKeyBundle(sshkeypath)
+	if err != nil {
+		return err
+	}
+
+	return writeToDevice(device, 0, tarBuf.Bytes())
+}
+
+func destroyZVol(zvol string) error {
+	return easyCmd("sudo", "zfs", "destroy", "-r", zvol)
+}
+
+// writeToDevice writes data at offset (in bytes, a multiple of 512) of a
+// device node only root can write to.
+func writeToDevice(device string, offset int64, data []byte) error {
+	log.Debugf("EXEC: sudo dd of=%s (%d bytes at %d)", device, len(data), offset)
+	cmd := exec.Command("sudo", "dd", "of="+device, "bs=512", "seek="+strconv.FormatInt(offset/512, 10), "conv=notrunc,sync")
+	cmd.Stdin = bytes.NewReader(data)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("writing to %s failed: %s", device, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// readFromDevice reads length bytes at offset (in bytes, a multiple of 512)
+// of a device node only root can read.
+func readFromDevice(device string, offset int64, length int) ([]byte, error) {
+	log.Debugf("EXEC: sudo dd if=%s (%d bytes at %d)", device, length, offset)
+	cmd := exec.Command("sudo", "dd", "if="+device, "bs=512", "skip="+strconv.FormatInt(offset/512, 10),
+		"count="+strconv.Itoa((length+511)/512))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("reading from %s failed: %s", device, strings.TrimSpace(stderr.String()))
+	}
+	if len(out) < length {
+		return nil, fmt.Errorf("short read from %s", device)
+	}
+	return out[:length], nil
+}
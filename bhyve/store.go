@@ -0,0 +1,100 @@
+// Copyright 2019 Steve Wills. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bhyve
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const configFilename = "config.json"
+
+// LoadDriver reads the bhyve driver state of machineName from the
+// docker-machine store at storePath.
+func LoadDriver(storePath string, machineName string) (*Driver, error) {
+	data, err := ioutil.ReadFile(filepath.Join(storePath, "machines", machineName, configFilename))
+	if err != nil {
+		return nil, err
+	}
+
+	var host struct {
+		DriverName string
+		Driver     json.RawMessage
+	}
+	if err := json.Unmarshal(data, &host); err != nil {
+		return nil, err
+	}
+	if host.DriverName != "bhyve" {
+		return nil, fmt.Errorf("machine %s uses the %s driver, not bhyve", machineName, host.DriverName)
+	}
+
+	d := NewDriver(machineName, storePath)
+	if err := json.Unmarshal(host.Driver, d); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// ListMachines loads every bhyve machine in the docker-machine store at
+// storePath, skipping machines created by other drivers.
+func ListMachines(storePath string) ([]*Driver, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(storePath, "machines"))
+	if os.IsNotExist(err) {
+		// libmachine creates it when saving the first machine
+		return []*Driver{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	machines := []*Driver{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		d, err := LoadDriver(storePath, entry.Name())
+		if err != nil {
+			log.Debugf("Skipping %s: %s", entry.Name(), err)
+			continue
+		}
+		machines = append(machines, d)
+	}
+
+	return machines, nil
+}
+
+// SaveConfig writes the driver state back to the machine's config.json,
+// leaving the rest of the host configuration untouched.
+func (d *Driver) SaveConfig() error {
+	configPath := d.ResolveStorePath(configFilename)
+	data, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return err
+	}
+
+	host := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &host); err != nil {
+		return err
+	}
+
+	driver, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	host["Driver"] = driver
+
+	data, err = json.MarshalIndent(host, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(configPath, data, 0600)
+}
@@ -0,0 +1,161 @@
+// Copyright 2019 Steve Wills. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bhyve
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/machine/libmachine/log"
+	"github.com/docker/machine/libmachine/state"
+)
+
+const (
+	checkpointFilename = "checkpoint"
+	checkpointTimeout  = 300 // seconds
+)
+
+func (d *Driver) checkpointPath() string {
+	return d.ResolveStorePath(checkpointFilename)
+}
+
+func (d *Driver) removeCheckpoint() error {
+	for _, suffix := range []string{"", ".kern", ".meta"} {
+		if err := os.Remove(d.checkpointPath() + suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkpointSupported reports whether the host bhyve was built with
+// snapshot support, which adds --suspend to bhyvectl.
+func checkpointSupported() bool {
+	out, _ := exec.Command("bhyvectl").CombinedOutput()
+	return strings.Contains(string(out), "--suspend")
+}
+
+// waitForBhyveExit waits for the bhyve process of vmname to finish writing
+// its checkpoint and exit.
+func waitForBhyveExit(vmname string) error {
+	for tries := 0; tries < checkpointTimeout; tries++ {
+		if _, err := bhyvePID(vmname); err != nil {
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+	return fmt.Errorf("bhyve for %s didn't exit after %d seconds", vmname, checkpointTimeout)
+}
+
+// checkCheckpoint checks that bhyve wrote all of the checkpoint: the guest
+// memory, the kernel state and the metadata.
+func (d *Driver) checkCheckpoint() error {
+	info, err := os.Stat(d.checkpointPath())
+	if err != nil {
+		return err
+	}
+	if info.Size() < d.MemSize*1024*1024 {
+		return fmt.Errorf("checkpoint has only %d of %d bytes of memory", info.Size(), d.MemSize*1024*1024)
+	}
+
+	kern, err := os.Stat(d.checkpointPath() + ".kern")
+	if err != nil {
+		return err
+	}
+	if kern.Size() == 0 {
+		return errors.New("checkpoint kernel state is empty")
+	}
+
+	meta, err := ioutil.ReadFile(d.checkpointPath() + ".meta")
+	if err != nil {
+		return err
+	}
+	if !json.Valid(meta) {
+		return errors.New("checkpoint metadata is incomplete")
+	}
+
+	return nil
+}
+
+// Pause saves the state of a running machine to its directory and stops it
+// when the host bhyve supports checkpoints, so it survives host reboots.
+// Otherwise the bhyve process is frozen in memory.
+func (d *Driver) Pause() error {
+	s, err := d.GetState()
+	if err != nil {
+		return err
+	}
+	if s != state.Running {
+		return fmt.Errorf("machine %s is not running", d.MachineName)
+	}
+
+	if checkpointSupported() {
+		log.Infof("Saving state of %s...", d.MachineName)
+		if err := easyCmd("sudo", "bhyvectl", "--vm="+d.BhyveVMName, "--suspend="+d.checkpointPath()); err != nil {
+			return err
+		}
+		// bhyvectl only asks bhyve to save, bhyve exits once it is done
+		if err := waitForBhyveExit(d.BhyveVMName); err != nil {
+			return err
+		}
+		if err := d.checkCheckpoint(); err != nil {
+			_ = d.removeCheckpoint()
+			if terr := d.teardown(); terr != nil {
+				log.Debugf("Couldn't tear down %s: %s", d.MachineName, terr)
+			}
+			return fmt.Errorf("saving state of %s failed: %s", d.MachineName, err)
+		}
+		return d.teardown()
+	}
+
+	pid, err := findBhyvePID(d.BhyveVMName)
+	if err != nil {
+		return err
+	}
+	// the frozen guest can't answer SSH
+	if err := d.stopClockSync(); err != nil {
+		return err
+	}
+	log.Infof("Pausing %s...", d.MachineName)
+	if err := easyCmd("sudo", "kill", "-STOP", strconv.Itoa(pid)); err != nil {
+		return err
+	}
+	d.Paused = true
+
+	return nil
+}
+
+// Resume continues a machine paused or saved by Pause.
+func (d *Driver) Resume() error {
+	s, err := d.GetState()
+	if err != nil {
+		return err
+	}
+
+	switch s {
+	case state.Saved:
+		return d.Start()
+	case state.Paused:
+		pid, err := findBhyvePID(d.BhyveVMName)
+		if err != nil {
+			return err
+		}
+		log.Infof("Resuming %s...", d.MachineName)
+		if err := easyCmd("sudo", "kill", "-CONT", strconv.Itoa(pid)); err != nil {
+			return err
+		}
+		d.Paused = false
+		return d.startClockSync()
+	}
+
+	return fmt.Errorf("machine %s is not paused", d.MachineName)
+}
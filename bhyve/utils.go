@@ -152,9 +152,8 @@ func copyFile(src, dst string) (int64, error) {
 	return nBytes, nil
 }
 
-func ensureIPForwardingEnabled() error {
-	log.Debugf("Checking IP forwarding")
-	cmd := exec.Command("sysctl", "-n", "net.inet.ip.forwarding")
+func sysctlInt(name string) (int64, error) {
+	cmd := exec.Command("sysctl", "-n", name)
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
 	cmd.Stdout = &stdout
@@ -162,10 +161,15 @@ func ensureIPForwardingEnabled() error {
 
 	err := cmd.Run()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	isenabled, err := strconv.Atoi(strings.Trim(stdout.String(), "\n"))
+	return strconv.ParseInt(strings.TrimSpace(stdout.String()), 10, 64)
+}
+
+func ensureIPForwardingEnabled() error {
+	log.Debugf("Checking IP forwarding")
+	isenabled, err := sysctlInt("net.inet.ip.forwarding")
 	if err != nil {
 		return err
 	}
@@ -251,10 +255,15 @@ func writeDeviceMap(devmap string, cdpath string, diskname string) error {
 	return nil
 }
 
-func runGrub(devmap string, memsize string, vmname string) error {
+func runGrub(devmap string, memsize string, wired bool, vmname string) error {
+	args := []string{"env", "-i", "TERM=xterm", "/usr/local/sbin/grub-bhyve", "-m", devmap, "-r", "cd0", "-M", memsize + "M"}
+	if wired {
+		args = append(args, "-S")
+	}
+	args = append(args, vmname)
+
 	for maxtries := 0; maxtries < retrycount; maxtries++ {
-		cmd := exec.Command("sudo", "env", "-i", "TERM=xterm", "/usr/local/sbin/grub-bhyve",
-			"-m", devmap, "-r", "cd0", "-M", memsize+"M", vmname)
+		cmd := exec.Command("sudo", args...)
 		stdin, err := cmd.StdinPipe()
 		if err != nil {
 			return err
@@ -330,8 +339,11 @@ func startDHCPServer(dhcpdir string, bridge string, dhcprange string) error {
 			return err
 		}
 	}
-	// dnsmasq may leave it's PID file if killed?
-	if !fileExists(dhcppidfile) {
+	running, err := removeStalePIDFile(dhcppidfile, dnsmasqCommand)
+	if err != nil {
+		return err
+	}
+	if !running {
 		err := easyCmd("sudo", "dnsmasq", "-i", bridge, "-C", dhcpconffile, "-x", dhcppidfile, "-l", dhcpleasefile)
 		if err != nil {
 			return err
@@ -498,6 +510,18 @@ func setupnet(bridge string, subnet string) error {
 	return nil
 }
 
+// rotateConsoleLog keeps the console output of the previous boot in
+// console.log.old, so nothing reads it as output of the new one.
+func rotateConsoleLog(storepath string) error {
+	consolelog := filepath.Join(storepath, "console.log")
+	if !fileExists(consolelog) {
+		return nil
+	}
+
+	log.Debugf("Rotating %s", consolelog)
+	return os.Rename(consolelog, consolelog+".old")
+}
+
 func startConsoleLogger(storepath string, nmdmdev string) error {
 	dir, err := filepath.Abs(filepath.Dir(os.Args[0]))
 
@@ -506,7 +530,7 @@ func startConsoleLogger(storepath string, nmdmdev string) error {
 	}
 
 	err = easyCmd("/usr/sbin/daemon", "-f", "-p",
-		filepath.Join(storepath, "nmdm.pid"), dir+"/docker-machine-driver-bhyve-nmdm", nmdmdev+"B",
+		filepath.Join(storepath, "nmdm.pid"), filepath.Join(dir, nmdmLoggerCommand), nmdmdev+"B",
 		filepath.Join(storepath, "console.log"))
 	if err != nil {
 		return err
@@ -575,3 +599,132 @@ func checkRequireKmods() error {
 
 	return nil
 }
+
+func parseNetworkInterface(spec string) (NetworkInterface, error) {
+	parts := strings.Split(spec, ",")
+	nic := NetworkInterface{
+		Bridge: parts[0],
+		Model:  defaultNICModel,
+	}
+	if nic.Bridge == "" {
+		return nic, fmt.Errorf("invalid network %q: missing bridge name", spec)
+	}
+
+	for _, opt := range parts[1:] {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			return nic, fmt.Errorf("invalid network option %q in %q", opt, spec)
+		}
+		switch kv[0] {
+		case "mac":
+			if _, err := net.ParseMAC(kv[1]); err != nil {
+				return nic, fmt.Errorf("invalid MAC address %q in %q", kv[1], spec)
+			}
+			nic.MACAddress = kv[1]
+		case "model":
+			if kv[1] != "virtio-net" && kv[1] != "e1000" {
+				return nic, fmt.Errorf("unsupported NIC model %q in %q", kv[1], spec)
+			}
+			nic.Model = kv[1]
+		default:
+			return nic, fmt.Errorf("unknown network option %q in %q", kv[0], spec)
+		}
+	}
+
+	if nic.MACAddress == "" {
+		nic.MACAddress = generateMACAddress()
+	}
+
+	return nic, nil
+}
+
+func ensureBridge(bridge string) error {
+	if _, err := net.InterfaceByName(bridge); err == nil {
+		log.Debugf("Interface %s exists", bridge)
+		return nil
+	}
+
+	log.Debugf("Creating bridge %s", bridge)
+	err := easyCmd("sudo", "ifconfig", bridge, "create")
+	if err != nil {
+		return err
+	}
+
+	return easyCmd("sudo", "ifconfig", bridge, "up")
+}
+
+func parseExtraDisk(spec string, filename string) (ExtraDisk, error) {
+	parts := strings.Split(spec, ",")
+	disk := ExtraDisk{
+		Filename: filename,
+		Type:     defaultDiskType,
+	}
+
+	size, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || size <= 0 {
+		return disk, fmt.Errorf("invalid disk size %q in %q", parts[0], spec)
+	}
+	disk.Size = size * 1024 * 1024
+
+	if len(parts) > 2 {
+		return disk, fmt.Errorf("invalid extra disk %q", spec)
+	}
+	if len(parts) == 2 {
+		if parts[1] != "virtio-blk" && parts[1] != "nvme" {
+			return disk, fmt.Errorf("unsupported disk type %q in %q", parts[1], spec)
+		}
+		disk.Type = parts[1]
+	}
+
+	return disk, nil
+}
+
+func createSparseDisk(diskPath string, size int64) error {
+	log.Debugf("Creating %d byte disk %s", size, diskPath)
+	f, err := os.OpenFile(diskPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil
+		}
+		return err
+	}
+	f.Close()
+
+	return os.Truncate(diskPath, size)
+}
+
+// cloneFile copies src to dst with cp(1), which uses copy_file_range(2) and
+// so shares blocks instead of copying them where the filesystem supports it.
+func cloneFile(src string, dst string) error {
+	return easyCmd("cp", src, dst)
+}
+
+// lockFile takes an exclusive lock on path, which only needs to be readable,
+// and returns the function releasing it.
+func lockFile(path string) (func(), error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// writeFileAsRoot replaces the contents of a file only root can write.
+func writeFileAsRoot(path string, data []byte) error {
+	log.Debugf("EXEC: sudo tee %s", path)
+	cmd := exec.Command("sudo", "tee", path)
+	cmd.Stdin = bytes.NewReader(data)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("writing %s failed: %s", path, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
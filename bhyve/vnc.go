@@ -0,0 +1,78 @@
+// Copyright 2019 Steve Wills. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bhyve
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+
+	"github.com/docker/machine/libmachine/log"
+)
+
+const (
+	vncPortFirst = 5900
+	vncPortLast  = 5999
+)
+
+var vncResolutionRegexp = regexp.MustCompile(`^([0-9]+)x([0-9]+)$`)
+
+func parseVNCResolution(resolution string) (string, string, error) {
+	m := vncResolutionRegexp.FindStringSubmatch(resolution)
+	if m == nil {
+		return "", "", fmt.Errorf("invalid VNC resolution %q, must be <width>x<height>", resolution)
+	}
+	return m[1], m[2], nil
+}
+
+// allocateVNCPort picks the first port in the VNC range that no other
+// machine in the store uses and that is free to bind on the host.
+func (d *Driver) allocateVNCPort() (int, error) {
+	machines, err := ListMachines(d.StorePath)
+	if err != nil {
+		return 0, err
+	}
+
+	used := map[int]bool{}
+	for _, m := range machines {
+		if m.MachineName != d.MachineName && m.VNC {
+			used[m.VNCPort] = true
+		}
+	}
+
+	for port := vncPortFirst; port <= vncPortLast; port++ {
+		if used[port] {
+			continue
+		}
+		l, err := net.Listen("tcp", net.JoinHostPort(d.VNCBind, strconv.Itoa(port)))
+		if err != nil {
+			continue
+		}
+		l.Close()
+		log.Debugf("Using VNC port %d", port)
+		return port, nil
+	}
+
+	return 0, fmt.Errorf("no free VNC port between %d and %d", vncPortFirst, vncPortLast)
+}
+
+// VNCURL returns the URL of the VNC console of the machine.
+func (d *Driver) VNCURL() (string, error) {
+	if !d.VNC {
+		return "", fmt.Errorf("machine %s has no VNC console", d.MachineName)
+	}
+	if d.VNCPort == 0 {
+		return "", errors.New("VNC port not allocated yet, start the machine first")
+	}
+
+	host := d.VNCBind
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		host = "localhost"
+	}
+
+	return "vnc://" + net.JoinHostPort(host, strconv.Itoa(d.VNCPort)), nil
+}
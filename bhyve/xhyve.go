@@ -189,13 +189,13 @@ func generateRawDiskImage(sshkeypath string, diskPath string, size int64) error
 	return nil
 }
 
-func waitForIP(storepath string, macaddress string) (string, error) {
+func waitForIP(getIP func() (string, error)) (string, error) {
 	var ip string
 	var err error
 
 	log.Infof("Waiting for VM to come online...")
 	for i := 1; i <= 60; i++ {
-		ip, err = getIPfromDHCPLease(filepath.Join(storepath, "bhyve.leases"), macaddress)
+		ip, err = getIP()
 		if err != nil {
 			log.Debugf("Not there yet %d/%d, error: %s", i, 60, err)
 			time.Sleep(2 * time.Second)
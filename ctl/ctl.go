@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/docker/machine/commands/mcndirs"
+	"github.com/docker/machine/libmachine/state"
+	"gitlab.mouf.net/swills/docker-machine-driver-bhyve/bhyve"
+)
+
+type command struct {
+	usage string
+	run   func(d *bhyve.Driver, args []string) error
+}
+
+var errUsage = errors.New("invalid arguments")
+
+var commands = map[string]command{
+	"snapshot":  {"<machine> list|create|restore|delete [name]", snapshot},
+	"resize":    {"<machine> <size in MB>", resize},
+	"set":       {"<machine> [-cpus n] [-memory MB] [-sockets n -cores n -threads n]", set},
+	"vnc":       {"<machine>", vnc},
+	"clocksync": {"<machine> [-interval seconds]", clocksync},
+	"pause":     {"<machine>", pause},
+	"resume":    {"<machine>", resume},
+	"stats":     {"<machine> [-json]", stats},
+}
+
+type hostCommand struct {
+	usage string
+	run   func(storePath string, args []string) error
+}
+
+// hostCommands operate on the whole host rather than a single machine
+var hostCommands = map[string]hostCommand{
+	"inventory": {"[-json] [-reap]", inventory},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s [-s storage-path] <command> [machine] [args]\n\ncommands:\n", os.Args[0])
+	for name, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %s %s\n", name, cmd.usage)
+	}
+	for name, cmd := range hostCommands {
+		fmt.Fprintf(os.Stderr, "  %s %s\n", name, cmd.usage)
+	}
+	os.Exit(2)
+}
+
+func snapshot(d *bhyve.Driver, args []string) error {
+	if len(args) < 1 {
+		return errUsage
+	}
+
+	if args[0] == "list" {
+		snapshots, err := d.Snapshots()
+		if err != nil {
+			return err
+		}
+		for _, snap := range snapshots {
+			fmt.Printf("%s\t%s\t%dMB\t%s\n", snap.Name, snap.Time.Format(time.RFC3339), snap.DiskSize/1024/1024, snap.ISOVersion)
+		}
+		return nil
+	}
+
+	if len(args) != 2 {
+		return errUsage
+	}
+
+	switch args[0] {
+	case "create":
+		return d.CreateSnapshot(args[1])
+	case "restore":
+		if err := d.RestoreSnapshot(args[1]); err != nil {
+			return err
+		}
+		return d.SaveConfig()
+	case "delete":
+		return d.DeleteSnapshot(args[1])
+	}
+
+	return errUsage
+}
+
+func resize(d *bhyve.Driver, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	size, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	if err := d.ResizeDisk(size * 1024 * 1024); err != nil {
+		return err
+	}
+	return d.SaveConfig()
+}
+
+func set(d *bhyve.Driver, args []string) error {
+	fs := flag.NewFlagSet("set", flag.ContinueOnError)
+	cpus := fs.Int("cpus", d.CPUcount, "number of CPUs")
+	memory := fs.Int64("memory", d.MemSize, "memory in MB")
+	sockets := fs.Int("sockets", d.CPUSockets, "CPU sockets")
+	cores := fs.Int("cores", d.CPUCores, "cores per socket")
+	threads := fs.Int("threads", d.CPUThreads, "threads per core")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+
+	if err := d.SetResources(*cpus, *memory, *sockets, *cores, *threads); err != nil {
+		return err
+	}
+	return d.SaveConfig()
+}
+
+func vnc(d *bhyve.Driver, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	url, err := d.VNCURL()
+	if err != nil {
+		return err
+	}
+	fmt.Println(url)
+	return nil
+}
+
+func clocksync(d *bhyve.Driver, args []string) error {
+	fs := flag.NewFlagSet("clocksync", flag.ContinueOnError)
+	interval := fs.Int("interval", d.ClockSyncInterval, "seconds between syncs, 0 to sync once")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+
+	for {
+		if s, _ := d.GetState(); s != state.Running {
+			return fmt.Errorf("machine %s is not running", d.MachineName)
+		}
+		if err := d.SyncClock(); err != nil {
+			log.Print(err)
+		}
+		if *interval <= 0 {
+			return nil
+		}
+		time.Sleep(time.Duration(*interval) * time.Second)
+	}
+}
+
+func pause(d *bhyve.Driver, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	if err := d.Pause(); err != nil {
+		return err
+	}
+	return d.SaveConfig()
+}
+
+func resume(d *bhyve.Driver, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	if err := d.Resume(); err != nil {
+		return err
+	}
+	return d.SaveConfig()
+}
+
+func stats(d *bhyve.Driver, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print statistics as JSON")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+
+	st, err := d.Stats()
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(st)
+	}
+
+	fmt.Printf("machine:\t%s (%s)\n", st.Machine, st.VM)
+	fmt.Printf("uptime:\t\t%s\n", time.Duration(st.Uptime)*time.Second)
+	fmt.Printf("memory:\t\t%dMB resident, %dMB wired\n", st.ResidentMemory/1024/1024, st.WiredMemory/1024/1024)
+	for _, vcpu := range st.VCPUs {
+		fmt.Printf("vcpu%d:\t\t%d exits\n", vcpu.VCPU, vcpu.Exits)
+	}
+	for _, disk := range st.Disks {
+		fmt.Printf("%s:\t\t%d reads/%d bytes, %d writes/%d bytes (%s)\n", disk.Device,
+			disk.Reads, disk.ReadBytes, disk.Writes, disk.WriteBytes, disk.Path)
+	}
+	for _, iface := range st.Interfaces {
+		fmt.Printf("%s:\t\t%d packets/%d bytes in, %d packets/%d bytes out\n", iface.Name,
+			iface.InPackets, iface.InBytes, iface.OutPackets, iface.OutBytes)
+	}
+	return nil
+}
+
+func inventory(storePath string, args []string) error {
+	fs := flag.NewFlagSet("inventory", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the inventory as JSON")
+	reap := fs.Bool("reap", false, "destroy orphaned VMs and tap devices")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+
+	entries, err := bhyve.Inventory(storePath)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(entries); err != nil {
+			return err
+		}
+	} else {
+		for _, entry := range entries {
+			fmt.Println(entry)
+		}
+	}
+
+	if *reap {
+		return bhyve.ReapOrphans(entries)
+	}
+	return nil
+}
+
+func main() {
+	storePath := flag.String("s", mcndirs.GetBaseDir(), "docker-machine storage path")
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) > 0 {
+		if cmd, ok := hostCommands[args[0]]; ok {
+			err := cmd.run(*storePath, args[1:])
+			if err == errUsage {
+				usage()
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+			return
+		}
+	}
+
+	if len(args) < 2 {
+		usage()
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		usage()
+	}
+
+	d, err := bhyve.LoadDriver(*storePath, args[1])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = cmd.run(d, args[2:])
+	if err == errUsage {
+		usage()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
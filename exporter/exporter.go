@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/docker/machine/commands/mcndirs"
+	"github.com/docker/machine/libmachine/state"
+	"gitlab.mouf.net/swills/docker-machine-driver-bhyve/bhyve"
+)
+
+type metric struct {
+	kind    string
+	help    string
+	samples []string
+}
+
+type metrics map[string]*metric
+
+func (m metrics) sample(kind string, name string, help string, labels string, value interface{}) {
+	if _, ok := m[name]; !ok {
+		m[name] = &metric{kind: kind, help: help}
+	}
+	m[name].samples = append(m[name].samples, fmt.Sprintf("%s{%s} %v", name, labels, value))
+}
+
+func (m metrics) add(name string, help string, labels string, value interface{}) {
+	m.sample("gauge", name, help, labels, value)
+}
+
+// count adds a sample of a value that only grows, except when its source
+// is reset.
+func (m metrics) count(name string, help string, labels string, value interface{}) {
+	m.sample("counter", name, help, labels, value)
+}
+
+func (m metrics) write(buf *bytes.Buffer) {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", name, m[name].help, name, m[name].kind)
+		for _, sample := range m[name].samples {
+			fmt.Fprintln(buf, sample)
+		}
+	}
+}
+
+func collect(storePath string) (metrics, error) {
+	machines, err := bhyve.ListMachines(storePath)
+	if err != nil {
+		return nil, err
+	}
+
+	m := metrics{}
+	for _, d := range machines {
+		labels := fmt.Sprintf("machine=%q,vm=%q", d.MachineName, d.BhyveVMName)
+
+		s, err := d.GetState()
+		if err != nil {
+			log.Printf("%s: %s", d.MachineName, err)
+			continue
+		}
+		for _, st := range []state.State{state.Running, state.Paused, state.Saved, state.Stopped} {
+			value := 0
+			if s == st {
+				value = 1
+			}
+			m.add("bhyve_machine_state", "Current state of the machine.", fmt.Sprintf("%s,state=%q", labels, st), value)
+		}
+
+		m.add("bhyve_machine_vcpus", "Number of vCPUs of the machine.", labels, d.CPUcount)
+		m.add("bhyve_machine_memory_bytes", "Memory size of the machine.", labels, d.MemSize*1024*1024)
+		m.add("bhyve_machine_disk_allocated_bytes", "Size of the machine's guest disk.", labels, d.DiskSize)
+		if used, err := d.DiskUsage(); err == nil {
+			m.add("bhyve_machine_disk_used_bytes", "Host space used by the machine's guest disk.", labels, used)
+		} else {
+			log.Printf("%s: %s", d.MachineName, err)
+		}
+
+		if s != state.Running {
+			continue
+		}
+		st, err := d.Stats()
+		if err != nil {
+			log.Printf("%s: %s", d.MachineName, err)
+			continue
+		}
+		m.add("bhyve_machine_uptime_seconds", "Time since the machine's bhyve process started.", labels, st.Uptime)
+		m.add("bhyve_machine_resident_memory_bytes", "Guest memory resident on the host.", labels, st.ResidentMemory)
+		for _, disk := range st.Disks {
+			disklabels := fmt.Sprintf("%s,device=%q", labels, disk.Device)
+			m.count("bhyve_machine_disk_read_bytes_total", "Bytes read by the guest from a disk.", disklabels, disk.ReadBytes)
+			m.count("bhyve_machine_disk_written_bytes_total", "Bytes written by the guest to a disk.", disklabels, disk.WriteBytes)
+			m.count("bhyve_machine_disk_reads_completed_total", "Reads completed by the guest on a disk.", disklabels, disk.Reads)
+			m.count("bhyve_machine_disk_writes_completed_total", "Writes completed by the guest on a disk.", disklabels, disk.Writes)
+		}
+		for _, iface := range st.Interfaces {
+			iflabels := fmt.Sprintf("%s,interface=%q", labels, iface.Name)
+			m.count("bhyve_machine_network_receive_bytes_total", "Bytes received by the machine on a tap device.", iflabels, iface.OutBytes)
+			m.count("bhyve_machine_network_transmit_bytes_total", "Bytes sent by the machine on a tap device.", iflabels, iface.InBytes)
+		}
+	}
+
+	return m, nil
+}
+
+func main() {
+	storePath := flag.String("s", mcndirs.GetBaseDir(), "docker-machine storage path")
+	listen := flag.String("l", ":9755", "address to serve metrics on")
+	flag.Parse()
+
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		m, err := collect(*storePath)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var buf bytes.Buffer
+		m.write(&buf)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, _ = w.Write(buf.Bytes())
+	})
+
+	log.Printf("Serving metrics for machines in %s on %s", *storePath, *listen)
+	log.Fatal(http.ListenAndServe(*listen, nil))
+}